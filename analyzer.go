@@ -0,0 +1,290 @@
+package mongosearch
+
+import (
+	"fmt"
+	"github.com/300brand/searchquery"
+	"github.com/blevesearch/snowballstem"
+	"github.com/blevesearch/snowballstem/danish"
+	"github.com/blevesearch/snowballstem/dutch"
+	"github.com/blevesearch/snowballstem/english"
+	"github.com/blevesearch/snowballstem/finnish"
+	"github.com/blevesearch/snowballstem/french"
+	"github.com/blevesearch/snowballstem/german"
+	"github.com/blevesearch/snowballstem/hungarian"
+	"github.com/blevesearch/snowballstem/italian"
+	"github.com/blevesearch/snowballstem/norwegian"
+	"github.com/blevesearch/snowballstem/porter"
+	"github.com/blevesearch/snowballstem/portuguese"
+	"github.com/blevesearch/snowballstem/romanian"
+	"github.com/blevesearch/snowballstem/russian"
+	"github.com/blevesearch/snowballstem/spanish"
+	"github.com/blevesearch/snowballstem/swedish"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+// Analyzer turns text into the terms which are stored and searched for
+type Analyzer interface {
+	Analyze(text string) []string
+}
+
+// CaseFolder may be implemented by an Analyzer to tell whether its terms are
+// always lowercase, in which case the literal parts of wildcard terms, which
+// are not analyzed, are lowercased to match
+type CaseFolder interface {
+	FoldsCase() bool
+}
+
+// Tokenizer splits text into tokens
+type Tokenizer func(text string) []string
+
+// TokenFilter transforms a single token. Returning "" drops the token.
+type TokenFilter func(token string) string
+
+// Chain is an Analyzer running a Tokenizer and then each filter in turn
+type Chain struct {
+	Tokenizer Tokenizer     // Splits the text; strings.Fields if nil
+	Filters   []TokenFilter // Applied in order to every token
+	FoldCase  bool          // Terms come out lowercase; see CaseFolder
+}
+
+// NewAnalyzer returns a Chain of tokenizer and filters
+func NewAnalyzer(tokenizer Tokenizer, filters ...TokenFilter) *Chain {
+	return &Chain{Tokenizer: tokenizer, Filters: filters}
+}
+
+// NewEnglishAnalyzer returns an Analyzer which lowercases, drops
+// EnglishStopwords and applies the Snowball English stemmer
+func NewEnglishAnalyzer() *Chain {
+	stem, _ := NewStemFilter("english")
+	c := NewAnalyzer(nil, LowercaseFilter, NewStopwordFilter(EnglishStopwords...), stem)
+	c.FoldCase = true
+	return c
+}
+
+// FoldsCase reports whether FoldCase is set
+func (c *Chain) FoldsCase() bool {
+	return c.FoldCase
+}
+
+func (c *Chain) Analyze(text string) (terms []string) {
+	tokenize := c.Tokenizer
+	if tokenize == nil {
+		tokenize = strings.Fields
+	}
+	for _, token := range tokenize(text) {
+		for _, filter := range c.Filters {
+			if token = filter(token); token == "" {
+				break
+			}
+		}
+		if token != "" {
+			terms = append(terms, token)
+		}
+	}
+	return
+}
+
+// SetAnalyzer sets the Analyzer applied to keyword terms, after any
+// normalization. Items must be stored analyzed the same way; see Ingest.
+func (s *MongoSearch) SetAnalyzer(a Analyzer) {
+	s.analyzer = a
+}
+
+// LowercaseFilter lowercases tokens
+var LowercaseFilter TokenFilter = strings.ToLower
+
+// EnglishStopwords are common English words not worth searching for
+var EnglishStopwords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in",
+	"into", "is", "it", "no", "not", "of", "on", "or", "such", "that", "the",
+	"their", "then", "there", "these", "they", "this", "to", "was", "will",
+	"with",
+}
+
+// NewStopwordFilter returns a filter dropping words, in any case
+func NewStopwordFilter(words ...string) TokenFilter {
+	stop := make(map[string]bool, len(words))
+	for _, w := range words {
+		stop[strings.ToLower(w)] = true
+	}
+	return func(token string) string {
+		if stop[strings.ToLower(token)] {
+			return ""
+		}
+		return token
+	}
+}
+
+// stemmers are the Snowball stemmers by language. Porter is the original
+// English algorithm; english is its successor, Porter2.
+var stemmers = map[string]func(*snowballstem.Env) bool{
+	"danish":     danish.Stem,
+	"dutch":      dutch.Stem,
+	"english":    english.Stem,
+	"finnish":    finnish.Stem,
+	"french":     french.Stem,
+	"german":     german.Stem,
+	"hungarian":  hungarian.Stem,
+	"italian":    italian.Stem,
+	"norwegian":  norwegian.Stem,
+	"porter":     porter.Stem,
+	"portuguese": portuguese.Stem,
+	"romanian":   romanian.Stem,
+	"russian":    russian.Stem,
+	"spanish":    spanish.Stem,
+	"swedish":    swedish.Stem,
+}
+
+// NewStemFilter returns a filter reducing words to their stems with the
+// Snowball stemmer for language, or "porter" for the Porter stemmer. Tokens
+// should already be lowercase.
+func NewStemFilter(language string) (f TokenFilter, err error) {
+	stem, ok := stemmers[strings.ToLower(language)]
+	if !ok {
+		err = fmt.Errorf("Unknown stemming language: %s", language)
+		return
+	}
+	f = func(token string) string {
+		env := snowballstem.NewEnv(token)
+		stem(env)
+		return env.Current()
+	}
+	return
+}
+
+// analyze normalizes and analyzes text into terms
+func (s *MongoSearch) analyze(text string) (terms []string) {
+	terms = s.normalization.Words(text)
+	if s.analyzer != nil && len(terms) > 0 {
+		terms = s.analyzer.Analyze(strings.Join(terms, " "))
+	}
+	return
+}
+
+// analyzeTerms normalizes and analyzes the words of a keyword value.
+// Wildcards in terms are kept, while regular expression terms and proximity
+// operators are left alone.
+func (s *MongoSearch) analyzeTerms(value string) (out string, err error) {
+	if s.normalization&NormalizeAll == NormalizeNone && s.analyzer == nil {
+		return value, nil
+	}
+	var words, plain []string
+	flush := func() {
+		if len(plain) > 0 {
+			words = append(words, s.analyze(strings.Join(plain, " "))...)
+			plain = plain[:0]
+		}
+	}
+	fold := s.foldsCase()
+	for _, word := range strings.Fields(value) {
+		switch {
+		case isRegexTerm(word) || nearToken.MatchString(word):
+			flush()
+			words = append(words, word)
+		case isPattern(word):
+			flush()
+			for _, w := range s.normalization.words(word, "*?") {
+				if fold {
+					w = strings.ToLower(w)
+				}
+				words = append(words, w)
+			}
+		default:
+			plain = append(plain, word)
+		}
+	}
+	flush()
+	if len(words) == 0 && value != "" {
+		err = fmt.Errorf("Nothing left of %q to search for", value)
+		return
+	}
+	return strings.Join(words, " "), nil
+}
+
+// dropEmptyTerms removes the keyword terms of query analysis leaves nothing
+// of, such as stopwords, along with any groups left empty, so alternatives to
+// them still count. It is an error for no keyword terms to remain.
+func (s *MongoSearch) dropEmptyTerms(query *searchquery.Query) (err error) {
+	if s.normalization&NormalizeAll == NormalizeNone && s.analyzer == nil {
+		return
+	}
+	var dropped []string
+	if kept := s.keepTerms(query, &dropped); kept == 0 && len(dropped) > 0 {
+		err = fmt.Errorf("Nothing left of %q to search for", strings.Join(dropped, " "))
+	}
+	return
+}
+
+// keepTerms drops the empty keyword terms of query, adding them to dropped,
+// and returns the number of terms left to match
+func (s *MongoSearch) keepTerms(query *searchquery.Query, dropped *[]string) (kept int) {
+	for i, subqueries := range []*[]searchquery.SubQuery{&query.Required, &query.Optional, &query.Excluded} {
+		left := (*subqueries)[:0]
+		for _, sq := range *subqueries {
+			n := 1
+			if sq.Query != nil {
+				if n = s.keepTerms(sq.Query, dropped); len(sq.Query.Required)+len(sq.Query.Optional) == 0 {
+					continue
+				}
+			} else {
+				field := sq.Field
+				if newName, ok := s.Rewrites[field]; ok {
+					field = newName
+				}
+				if field != s.fields.keyword {
+					n = 0
+				} else if out, _ := s.analyzeTerms(sq.Value); out == "" {
+					*dropped = append(*dropped, sq.Value)
+					continue
+				}
+			}
+			// Exclusions alone leave nothing to match
+			if i < 2 {
+				kept += n
+			}
+			left = append(left, sq)
+		}
+		*subqueries = left
+	}
+	return
+}
+
+// foldsCase reports whether the analyzer says it lowercases terms; see
+// CaseFolder
+func (s *MongoSearch) foldsCase() bool {
+	folder, ok := s.analyzer.(CaseFolder)
+	return ok && folder.FoldsCase()
+}
+
+// Ingest returns the fields to store with an item for its text to be found,
+// normalized and analyzed as queries are: the all-words array, the distinct
+// keywords and, if SetShingles was called, the shingles. Keys are the field
+// names given to SetAll, SetKeyword and SetShingles.
+func (s *MongoSearch) Ingest(text string) (fields bson.M) {
+	all := s.analyze(text)
+	if all == nil {
+		all = []string{}
+	}
+
+	seen := make(map[string]bool, len(all))
+	keywords := make([]string, 0, len(all))
+	for _, term := range all {
+		if !seen[term] {
+			seen[term] = true
+			keywords = append(keywords, term)
+		}
+	}
+
+	fields = bson.M{}
+	if s.fields.all != "" {
+		fields[s.fields.all] = all
+	}
+	if s.fields.keyword != "" {
+		fields[s.fields.keyword] = keywords
+	}
+	if s.fields.shingle != "" {
+		fields[s.fields.shingle] = Shingles(all)
+	}
+	return
+}
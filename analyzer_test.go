@@ -0,0 +1,240 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyzer(t *testing.T) {
+	english := NewEnglishAnalyzer()
+	tests := []struct {
+		Analyzer Analyzer
+		Text     string
+		Terms    []string
+	}{
+		{english, "The Clouds are running in data centers", []string{"cloud", "run", "data", "center"}},
+		{english, "the of and", nil},
+		{NewAnalyzer(nil, LowercaseFilter), "Data Centers", []string{"data", "centers"}},
+		{NewAnalyzer(nil, NewStopwordFilter("THE")), "The data", []string{"data"}},
+		{NewAnalyzer(func(text string) []string { return []string{text} }), "data center", []string{"data center"}},
+	}
+	for _, test := range tests {
+		if terms := test.Analyzer.Analyze(test.Text); !reflect.DeepEqual(terms, test.Terms) {
+			t.Errorf("%q: expected %q, got %q", test.Text, test.Terms, terms)
+		}
+	}
+}
+
+// upperAnalyzer uppercases terms, claiming to fold case or not as told
+type upperAnalyzer bool
+
+func (a upperAnalyzer) Analyze(text string) []string {
+	return strings.Fields(strings.ToUpper(text))
+}
+
+func (a upperAnalyzer) FoldsCase() bool {
+	return bool(a)
+}
+
+func TestFoldsCase(t *testing.T) {
+	tests := []struct {
+		Analyzer Analyzer
+		Expect   string
+	}{
+		{nil, `^Secur`},
+		{NewEnglishAnalyzer(), `^secur`},
+		{NewAnalyzer(nil, LowercaseFilter), `^Secur`},
+		{&Chain{Filters: []TokenFilter{LowercaseFilter}, FoldCase: true}, `^secur`},
+		{upperAnalyzer(false), `^Secur`},
+		{upperAnalyzer(true), `^secur`},
+	}
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		if test.Analyzer != nil {
+			ms.SetAnalyzer(test.Analyzer)
+		}
+
+		query, _ := parseQuery(`published:2014-06-01 AND keywords:Secur*`)
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		expect := `{"$or":[{"keywords":{"$regex":"` + test.Expect + `"},"pubdate":20140601}]}`
+		if b, _ := json.Marshal(mgoQuery); string(b) != expect {
+			t.Errorf("[%d] Expect: %s", i, expect)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+	}
+}
+
+func TestStemFilter(t *testing.T) {
+	tests := []struct {
+		Language string
+		Word     string
+		Stem     string
+	}{
+		{"english", "clouds", "cloud"},
+		{"english", "generously", "generous"},
+		{"porter", "generously", "gener"},
+		{"English", "running", "run"},
+		{"spanish", "nubes", "nub"},
+	}
+	for _, test := range tests {
+		stem, err := NewStemFilter(test.Language)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Language, err)
+		}
+		if s := stem(test.Word); s != test.Stem {
+			t.Errorf("%s %q: expected %q, got %q", test.Language, test.Word, test.Stem, s)
+		}
+	}
+	if _, err := NewStemFilter("klingon"); err == nil {
+		t.Error("Expected an error for an unknown language")
+	}
+}
+
+func TestBuildQueryAnalyzed(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Expect string
+	}{
+		{
+			`published:2014-06-01 AND keywords:Clouds`,
+			`{"$or":[{"keywords":"cloud","pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:"the Data-Centers"`,
+			`{"$or":[{"keywords":{"$all":["data","center"]},"pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:"running NEAR/2 clou*"`,
+			`{"$or":[{"keywords":{"$all":["run",{"Pattern":"^clou","Options":""}]},"pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:Secur*`,
+			`{"$or":[{"keywords":{"$regex":"^secur"},"pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:(cloud OR the)`,
+			`{"$or":[{"keywords":"cloud","pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:((cloud OR the) NOT of)`,
+			`{"$or":[{"keywords":"cloud","pubdate":20140601}]}`,
+		},
+	}
+	for _, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetNormalization(NormalizePunctuation)
+		ms.SetAnalyzer(NewEnglishAnalyzer())
+
+		query, err := parseQuery(test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Input, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Expect {
+			t.Errorf("Input:  %s", test.Input)
+			t.Errorf("Expect: %s", test.Expect)
+			t.Errorf("Got:    %s", b)
+		}
+	}
+
+	ms, _ := New("", "Items", "Results")
+	ms.SetKeyword("keywords", ConvertSpaces)
+	ms.SetPubdate("pubdate", ConvertDateInt, "published")
+	ms.SetAnalyzer(NewEnglishAnalyzer())
+	for _, input := range []string{
+		`published:2014-06-01 AND keywords:"the of"`,
+		`published:2014-06-01 AND keywords:(the OR of)`,
+	} {
+		query, _ := parseQuery(input)
+		if _, err := ms.buildQuery(query); err == nil {
+			t.Errorf("%s: expected an error for only stopwords", input)
+		}
+	}
+}
+
+func TestIngest(t *testing.T) {
+	s, err := NewWithStore(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetShingles("shingles")
+	s.SetNormalization(NormalizeAll)
+	s.SetAnalyzer(NewEnglishAnalyzer())
+
+	got, _ := json.Marshal(s.Ingest("The clouds, the clouds' data-centers."))
+	expect := `{"all":["cloud","cloud","data","center"],"keywords":["cloud","data","center"],"shingles":["cloud cloud","cloud data","data center"]}`
+	if string(got) != expect {
+		t.Errorf("Expect: %s", expect)
+		t.Errorf("Got:    %s", got)
+	}
+
+	got, _ = json.Marshal(s.Ingest("the"))
+	expect = `{"all":[],"keywords":[],"shingles":null}`
+	if string(got) != expect {
+		t.Errorf("Expect: %s", expect)
+		t.Errorf("Got:    %s", got)
+	}
+}
+
+func TestMemoryStoreAnalyzed(t *testing.T) {
+	s, err := NewWithStore(NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+	s.SetAnalyzer(NewEnglishAnalyzer())
+
+	store := s.store.(*MemoryStore)
+	for i, text := range []string{
+		"Google is running new data centers",
+		"Clouds over the data center",
+		"A cloud of dust",
+	} {
+		doc := s.Ingest(text)
+		doc["_id"] = i + 1
+		doc["pubid"] = pubs[0]
+		doc["date"] = time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+		if err := store.Insert(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		Query string
+		Ids   []int
+	}{
+		{`date:2014-06-01 AND keywords:cloud`, []int{2, 3}},
+		{`date:2014-06-01 AND keywords:"data center"`, []int{1, 2}},
+		{`date:2014-06-01 AND keywords:"the data centers"`, []int{1, 2}},
+		{`date:2014-06-01 AND keywords:"runs new"`, []int{1}},
+		{`date:2014-06-01 AND keywords:"clouds NEAR/1 data"`, []int{2}},
+		{`date:2014-06-01 AND keywords:"clouds NEAR/0 data"`, nil},
+	}
+	for _, test := range tests {
+		id, err := s.Search(test.Query)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Query, err)
+		}
+		if ids := memResults(t, s, id, ResultOptions{}); !reflect.DeepEqual(ids, test.Ids) {
+			t.Errorf("%s: expected %v, got %v", test.Query, test.Ids, ids)
+		}
+	}
+}
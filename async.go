@@ -0,0 +1,121 @@
+package mongosearch
+
+import (
+	"context"
+	"github.com/300brand/logger"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// SearchStatus is the state of a search as recorded in its metadata document
+type SearchStatus struct {
+	Id       bson.ObjectId `bson:"_id"`
+	Status   string
+	Error    string
+	Progress struct {
+		Done  int
+		Total int
+	}
+	Queued   time.Time
+	Start    time.Time
+	End      time.Time
+	Synonyms []Expansion // Keyword terms expanded by their synonyms
+}
+
+// Finished reports whether the search has stopped running, successfully or
+// otherwise
+func (st *SearchStatus) Finished() bool {
+	switch st.Status {
+	case StatusDone, StatusFailed, StatusCancelled, StatusExpired:
+		return true
+	}
+	return false
+}
+
+// SetWorkers limits the number of async searches running at once; the rest
+// wait in the queue. A limit of zero or less removes the cap. Defaults to 4.
+// Must be called before the first search.
+func (s *MongoSearch) SetWorkers(n int) {
+	if n <= 0 {
+		s.shared.workers = nil
+		return
+	}
+	s.shared.workers = make(chan struct{}, n)
+}
+
+// SearchAsync queues the search and returns its id without waiting for it to
+// run. Use Status or Wait to follow its progress. Queued and running searches
+// are cancelled by Close.
+func (s *MongoSearch) SearchAsync(query string) (id bson.ObjectId, err error) {
+	if err = s.checkFields(); err != nil {
+		return
+	}
+
+	id = bson.NewObjectId()
+	if err = s.store.SetMeta(id, bson.M{
+		"query": bson.M{
+			"original": query,
+		},
+		"status": StatusQueued,
+		"queued": time.Now(),
+	}); err != nil {
+		return
+	}
+
+	s.shared.Lock()
+	ctx := s.shared.ctx
+	s.shared.Unlock()
+
+	go s.runAsync(ctx, query, id)
+	return
+}
+
+// Status returns the current state of search id
+func (s *MongoSearch) Status(id bson.ObjectId) (status *SearchStatus, err error) {
+	status = new(SearchStatus)
+	if err = s.store.Meta(id, status); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Wait polls search id until it finishes or ctx is done. The last status seen
+// is returned either way.
+func (s *MongoSearch) Wait(ctx context.Context, id bson.ObjectId) (status *SearchStatus, err error) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if status, err = s.Status(id); err != nil || status.Finished() {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// runAsync waits for a free worker, then runs the search
+func (s *MongoSearch) runAsync(ctx context.Context, query string, id bson.ObjectId) {
+	if workers := s.shared.workers; workers != nil {
+		select {
+		case workers <- struct{}{}:
+			defer func() { <-workers }()
+		case <-ctx.Done():
+		}
+	}
+
+	// Cancelled while still queued; doSearch records everything after this
+	if err := ctx.Err(); err != nil {
+		if err := s.recordCancel(id, err); err != ctx.Err() {
+			logger.Error.Printf("Search %s: recording status: %s", id.Hex(), err)
+		}
+		return
+	}
+
+	if err := s.copy().doSearch(ctx, query, id); err != nil {
+		logger.Error.Printf("Search %s: %s", id.Hex(), err)
+	}
+}
@@ -0,0 +1,58 @@
+package mongosearch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSearchAsync(t *testing.T) {
+	if *ServerAddr == "" {
+		t.Skip("No mongo server provided")
+	}
+
+	resetDB(t)
+
+	s, err := New(*ServerAddr, "Items", "Results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+	s.SetPollInterval(10 * time.Millisecond)
+
+	id, err := s.SearchAsync("date:2014-06-02 AND (a OR b)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	status, err := s.Wait(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != StatusDone {
+		t.Errorf("Expected status %s, got %s (%s)", StatusDone, status.Status, status.Error)
+	}
+}
+
+func TestStatusFinished(t *testing.T) {
+	tests := map[string]bool{
+		StatusQueued:    false,
+		StatusRunning:   false,
+		StatusDone:      true,
+		StatusFailed:    true,
+		StatusCancelled: true,
+		StatusExpired:   true,
+	}
+	for status, finished := range tests {
+		st := &SearchStatus{Status: status}
+		if st.Finished() != finished {
+			t.Errorf("%s: expected Finished() = %v", status, finished)
+		}
+	}
+}
@@ -2,18 +2,100 @@ package mongosearch
 
 import (
 	"fmt"
+	"github.com/300brand/searchquery"
 	"labix.org/v2/mgo/bson"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Converter turns the value of a subquery into what is stored in the database
+type Converter interface {
+	Convert(c *Conversion) (Converted, error)
+}
+
+// Conversion is handed to a Converter with the subquery to convert and the
+// configuration of the MongoSearch doing the converting
+type Conversion struct {
+	Field    string               // Field name, after rewrites
+	SubQuery searchquery.SubQuery // Value and operator to convert
+	Location *time.Location       // From SetLocation; nil for UTC
+	Layouts  []string             // Date layouts, from SetDateLayouts
+	Now      time.Time            // Current time according to the clock
+	Time     time.Time            // Date bound resolved by the search itself, such as the date window; zero when SubQuery.Value is to be parsed
+}
+
+// ValueKind tells how a Converted value is matched
+type ValueKind int
+
+const (
+	ValueScalar  ValueKind = iota // Value matches as is
+	ValueAll                      // Value is a slice; all elements must match
+	ValueAny                      // Value is a slice; any element may match
+	ValueRange                    // From, inclusive, up to To, exclusive
+	ValuePattern                  // Value is a regular expression
+)
+
+// Converted is the result of a conversion
+type Converted struct {
+	Kind  ValueKind
+	Value interface{} // Scalar, or slice for ValueAll and ValueAny
+	From  interface{} // Start of a ValueRange; nil leaves it open
+	To    interface{} // End of a ValueRange; nil leaves it open
+}
+
+// FuncConverter adapts an ordinary function to a Converter
+type FuncConverter func(c *Conversion) (Converted, error)
+
+func (f FuncConverter) Convert(c *Conversion) (Converted, error) {
+	return f(c)
+}
+
+// ConversionError is returned when a Converter fails
+type ConversionError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("Error converting %s: %s", e.Field, e.Err)
+}
+
+// Unwrap returns the error of the Converter
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// ConversionFunc is the original, simpler form of Converter. An array result
+// is treated as ValueAll.
 type ConversionFunc func(string) (interface{}, bool, error)
 
-var ConvertDate ConversionFunc = func(in string) (out interface{}, isArray bool, err error) {
-	out, err = time.Parse(TimeLayout, in)
+func (f ConversionFunc) Convert(c *Conversion) (out Converted, err error) {
+	value, isArray, err := f(c.SubQuery.Value)
+	if err != nil {
+		return
+	}
+	out.Value = value
+	if isArray {
+		out.Kind = ValueAll
+	}
 	return
 }
 
+// DateConverter parses dates in the layouts and location of the MongoSearch,
+// which default to those in defaultLayouts and UTC. A date alone matches the
+// whole day.
+var DateConverter = NewDateConverter(nil, nil)
+
+// ConvertDate parses dates with DateConverter as configured by default, in
+// defaultLayouts and UTC. Having no range to return, a date alone gives the
+// start of the day. Use DateConverter to follow SetDateLayouts and
+// SetLocation.
+var ConvertDate ConversionFunc = func(in string) (out interface{}, isArray bool, err error) {
+	return convertDefault(DateConverter, in)
+}
+
 var ConvertBsonId ConversionFunc = func(in string) (out interface{}, isArray bool, err error) {
 	if !bson.IsObjectIdHex(in) {
 		err = fmt.Errorf("Invalid BSON ObjectId: %s", in)
@@ -37,12 +119,178 @@ var ConvertSpaces ConversionFunc = func(in string) (out interface{}, isArray boo
 	return
 }
 
+var ConvertInt ConversionFunc = func(in string) (out interface{}, isArray bool, err error) {
+	n, err := strconv.ParseInt(in, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("Invalid integer: %s", in)
+		return
+	}
+	out = n
+	return
+}
+
+var ConvertFloat ConversionFunc = func(in string) (out interface{}, isArray bool, err error) {
+	f, err := strconv.ParseFloat(in, 64)
+	if err != nil {
+		err = fmt.Errorf("Invalid number: %s", in)
+		return
+	}
+	out = f
+	return
+}
+
+// ConvertBool accepts the values strconv.ParseBool does, as well as yes and
+// no
+var ConvertBool ConversionFunc = func(in string) (out interface{}, isArray bool, err error) {
+	switch strings.ToLower(in) {
+	case "yes":
+		return true, false, nil
+	case "no":
+		return false, false, nil
+	}
+	b, err := strconv.ParseBool(in)
+	if err != nil {
+		err = fmt.Errorf("Invalid boolean: %s", in)
+		return
+	}
+	out = b
+	return
+}
+
+// NewEnumConverter returns a conversion accepting only the names in values,
+// in any case, converting each to the value stored in the database
+func NewEnumConverter(values map[string]interface{}) ConversionFunc {
+	lower := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		lower[strings.ToLower(name)] = value
+	}
+	return func(in string) (out interface{}, isArray bool, err error) {
+		out, ok := lower[strings.ToLower(in)]
+		if !ok {
+			err = fmt.Errorf("Unknown value: %s", in)
+		}
+		return
+	}
+}
+
+// NewWhitelistConverter returns a conversion accepting only the values given,
+// which are stored as is
+func NewWhitelistConverter(allowed ...string) ConversionFunc {
+	values := make(map[string]interface{}, len(allowed))
+	for _, v := range allowed {
+		values[v] = v
+	}
+	return NewEnumConverter(values)
+}
+
+// DateIntConverter converts to a YYYYMMDD integer. The day is taken in the
+// location of the MongoSearch if set, otherwise in the zone given with the
+// date or UTC.
+var DateIntConverter = NewDateIntConverter(nil, nil)
+
+// ConvertDateInt converts to a YYYYMMDD integer with DateIntConverter as
+// configured by default, taking the day in the zone given with the date or
+// UTC. Use DateIntConverter to follow SetDateLayouts and SetLocation.
 var ConvertDateInt ConversionFunc = func(in string) (out interface{}, isArray bool, err error) {
-	t, err := time.Parse(TimeLayout, in)
+	return convertDefault(DateIntConverter, in)
+}
+
+// convertDefault runs conv on in outside of any MongoSearch, giving the start
+// of a ValueRange as the value
+func convertDefault(conv Converter, in string) (out interface{}, isArray bool, err error) {
+	converted, err := conv.Convert(&Conversion{SubQuery: searchquery.SubQuery{Value: in}})
 	if err != nil {
 		return
 	}
-	y, m, d := t.Date()
-	out = y*1e4 + int(m)*1e2 + d
+	if converted.Kind == ValueRange {
+		return converted.From, false, nil
+	}
+	return converted.Value, false, nil
+}
+
+// defaultLayouts returns the layouts accepted by date conversions when none
+// are configured, starting with the current TimeLayout
+func defaultLayouts() []string {
+	return []string{
+		TimeLayout,
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		time.RFC3339,
+	}
+}
+
+// NewDateConverter returns a conversion to time.Time accepting any of layouts.
+// Dates without a zone are read in loc, and converted times are in loc. A date
+// without a time of day covers the whole day, from its start up to the start
+// of the next. Nil or empty arguments are taken from the MongoSearch
+// converting the date, as set with SetDateLayouts and SetLocation.
+func NewDateConverter(layouts []string, loc *time.Location) Converter {
+	return dateConverter(layouts, loc, func(t time.Time, day bool) Converted {
+		if day {
+			return Converted{Kind: ValueRange, From: t, To: t.AddDate(0, 0, 1)}
+		}
+		return Converted{Value: t}
+	})
+}
+
+// NewDateIntConverter returns a conversion to YYYYMMDD integers accepting any
+// of layouts, with days taken in loc. Nil or empty arguments are taken from
+// the MongoSearch converting the date, as with NewDateConverter.
+func NewDateIntConverter(layouts []string, loc *time.Location) Converter {
+	return dateConverter(layouts, loc, func(t time.Time, day bool) Converted {
+		y, m, d := t.Date()
+		return Converted{Value: y*1e4 + int(m)*1e2 + d}
+	})
+}
+
+// dateConverter parses dates with layouts in loc, falling back on those of the
+// Conversion, and hands them to convert along with whether they were given
+// without a time of day
+func dateConverter(layouts []string, loc *time.Location, convert func(t time.Time, day bool) Converted) FuncConverter {
+	layouts = append([]string(nil), layouts...)
+	return func(c *Conversion) (out Converted, err error) {
+		layouts, loc := layouts, loc
+		if len(layouts) == 0 {
+			layouts = c.Layouts
+		}
+		if len(layouts) == 0 {
+			layouts = defaultLayouts()
+		}
+		if loc == nil {
+			loc = c.Location
+		}
+		t, day := c.Time, false
+		if t.IsZero() {
+			if t, day, err = parseDate(c.SubQuery.Value, layouts, loc); err != nil {
+				return
+			}
+		}
+		if loc != nil {
+			t = t.In(loc)
+		}
+		return convert(t, day), nil
+	}
+}
+
+// parseDate tries each of layouts in turn, reading dates without a zone in loc
+// or UTC if it is nil. day reports whether the layout matched has no time of
+// day.
+func parseDate(in string, layouts []string, loc *time.Location) (t time.Time, day bool, err error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, layout := range layouts {
+		if t, err = time.ParseInLocation(layout, in, loc); err == nil {
+			return t, dateOnly(layout), nil
+		}
+	}
+	err = fmt.Errorf("Unrecognized date: %s", in)
 	return
 }
+
+// dateOnly reports whether layout drops the time of day
+func dateOnly(layout string) bool {
+	ref := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	t, err := time.Parse(layout, ref.Format(layout))
+	return err == nil && t.Hour()+t.Minute()+t.Second() == 0
+}
@@ -0,0 +1,465 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/300brand/searchquery"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvertDates(t *testing.T) {
+	edt := time.FixedZone("EDT", -4*3600)
+	tests := []struct {
+		Convert  Converter
+		Location *time.Location
+		In       string
+		Out      interface{}
+	}{
+		{DateConverter, nil, "2014-06-01T12:00:00Z", time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{DateConverter, nil, "2014-06-01 23:30:00", time.Date(2014, 6, 1, 23, 30, 0, 0, time.UTC)},
+		{DateConverter, nil, "2014-06-01T23:30:00", time.Date(2014, 6, 1, 23, 30, 0, 0, time.UTC)},
+		{DateConverter, edt, "2014-06-01 23:30:00", time.Date(2014, 6, 2, 3, 30, 0, 0, time.UTC)},
+		{DateIntConverter, nil, "2014-06-01", 20140601},
+		{DateIntConverter, nil, "2014-06-01T23:30:00-04:00", 20140601},
+		{DateIntConverter, nil, "2014-06-02T03:30:00Z", 20140602},
+		{DateIntConverter, edt, "2014-06-02T03:30:00Z", 20140601},
+		{NewDateConverter(nil, edt), nil, "2014-06-01T12:00:00", time.Date(2014, 6, 1, 16, 0, 0, 0, time.UTC)},
+		{NewDateConverter(nil, edt), nil, "2014-06-01 23:30:00", time.Date(2014, 6, 2, 3, 30, 0, 0, time.UTC)},
+		{NewDateIntConverter(nil, edt), time.UTC, "2014-06-02T03:30:00Z", 20140601},
+		{NewDateIntConverter(nil, edt), nil, "2014-06-01 23:30:00", 20140601},
+	}
+
+	for i, test := range tests {
+		out, err := test.Convert.Convert(&Conversion{
+			SubQuery: searchquery.SubQuery{Value: test.In},
+			Location: test.Location,
+		})
+		if err != nil {
+			t.Errorf("[%d] %s: %s", i, test.In, err)
+			continue
+		}
+		if expect, ok := test.Out.(time.Time); ok {
+			if got, _ := out.Value.(time.Time); !got.Equal(expect) {
+				t.Errorf("[%d] %s: expected %s, got %v", i, test.In, expect, out.Value)
+			}
+			continue
+		}
+		if out.Value != test.Out {
+			t.Errorf("[%d] %s: expected %v, got %v", i, test.In, test.Out, out.Value)
+		}
+	}
+
+	// A date alone covers the whole day in the location
+	from := time.Date(2014, 6, 1, 4, 0, 0, 0, time.UTC)
+	for i, conv := range []Converter{DateConverter, NewDateConverter(nil, edt)} {
+		out, err := conv.Convert(&Conversion{
+			SubQuery: searchquery.SubQuery{Value: "2014-06-01"},
+			Location: edt,
+		})
+		if err != nil {
+			t.Errorf("[%d] 2014-06-01: %s", i, err)
+			continue
+		}
+		start, _ := out.From.(time.Time)
+		end, _ := out.To.(time.Time)
+		if out.Kind != ValueRange || !start.Equal(from) || !end.Equal(from.AddDate(0, 0, 1)) {
+			t.Errorf("[%d] 2014-06-01: expected the day from %s, got %+v", i, from, out)
+		}
+	}
+
+	if _, err := DateConverter.Convert(&Conversion{SubQuery: searchquery.SubQuery{Value: "06/01/2014"}}); err == nil {
+		t.Error("Expected an error for an unrecognized layout")
+	}
+
+	// The plain functions still work on their own, in UTC
+	if out, _, err := ConvertDate("2014-06-01"); err != nil || !out.(time.Time).Equal(time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("ConvertDate: expected the start of 2014-06-01, got %v, %v", out, err)
+	}
+	if out, _, err := ConvertDateInt("2014-06-01T23:30:00-04:00"); err != nil || out != 20140601 {
+		t.Errorf("ConvertDateInt: expected 20140601, got %v, %v", out, err)
+	}
+	if _, _, err := ConvertDate("06/01/2014"); err == nil {
+		t.Error("ConvertDate: expected an error for an unrecognized layout")
+	}
+}
+
+func TestBuildQueryLocation(t *testing.T) {
+	edt := time.FixedZone("EDT", -4*3600)
+	tests := []struct {
+		Input string
+		Query string
+	}{
+		{
+			`published:today AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-06-01T00:00:00-04:00","$lt":"2014-06-02T00:00:00-04:00"}}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-06-01T00:00:00-04:00","$lt":"2014-06-02T00:00:00-04:00"}}]}`,
+		},
+		{
+			`published>=2014-05-01 AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-05-01T00:00:00-04:00"}}]}`,
+		},
+		{
+			`published>='2014-05-01T12:00:00Z' AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-05-01T08:00:00-04:00"}}]}`,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetAll("all")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", DateConverter, "published")
+		ms.SetPubid("pubid", ConvertBsonId)
+		ms.SetLocation(edt)
+		// Still the evening of June 1st in New York
+		ms.SetClock(func() time.Time {
+			return time.Date(2014, 6, 2, 2, 0, 0, 0, time.UTC)
+		})
+
+		query, err := parseQuery(test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+	}
+
+	// Integer days follow the location without a converter of their own
+	ms, _ := New("", "Items", "Results")
+	ms.SetKeyword("keywords", ConvertSpaces)
+	ms.SetPubdate("pubdate", DateIntConverter, "published")
+	ms.SetLocation(edt)
+	ms.SetClock(func() time.Time {
+		return time.Date(2014, 6, 2, 2, 0, 0, 0, time.UTC)
+	})
+	query, _ := parseQuery(`published:today AND keywords:a`)
+	mgoQuery, err := ms.buildQuery(query)
+	if err != nil {
+		t.Fatalf("buildQuery: %s", err)
+	}
+	expect := `{"$or":[{"keywords":"a","pubdate":{"$gte":20140601,"$lt":20140602}}]}`
+	if b, _ := json.Marshal(mgoQuery); string(b) != expect {
+		t.Errorf("Expect: %s", expect)
+		t.Errorf("Got:    %s", b)
+	}
+}
+
+func TestDateLayouts(t *testing.T) {
+	clock := func() time.Time {
+		return time.Date(2014, 6, 25, 15, 4, 5, 0, time.UTC)
+	}
+
+	eu, _ := New("", "Items", "Results")
+	eu.SetKeyword("keywords", ConvertSpaces)
+	eu.SetPubdate("pubdate", DateIntConverter, "published")
+	eu.SetDateLayouts("02/01/2006")
+	eu.SetClock(clock)
+
+	us, _ := New("", "Items", "Results")
+	us.SetKeyword("keywords", ConvertSpaces)
+	us.SetPubdate("pubdate", DateIntConverter, "published")
+	us.SetDateLayouts("01/02/2006")
+	us.SetClock(clock)
+
+	// Dates are read in the layouts and location as typed, not rewritten
+	edt := time.FixedZone("EDT", -4*3600)
+	dt, _ := New("", "Items", "Results")
+	dt.SetKeyword("keywords", ConvertSpaces)
+	dt.SetPubdate("pubdate", DateConverter, "published")
+	dt.SetDateLayouts("01/02/2006", "01/02/2006 15:04")
+	dt.SetLocation(edt)
+	dt.SetClock(clock)
+
+	tests := []struct {
+		s     *MongoSearch
+		Input string
+		Query string
+	}{
+		{eu, `published:'01/06/2014' AND keywords:a`, `{"$or":[{"keywords":"a","pubdate":20140601}]}`},
+		{us, `published:'01/06/2014' AND keywords:a`, `{"$or":[{"keywords":"a","pubdate":20140106}]}`},
+		{eu, `published:yesterday AND keywords:a`, `{"$or":[{"keywords":"a","pubdate":{"$gte":20140624,"$lt":20140625}}]}`},
+		{us, `keywords:a`, `{"$or":[{"keywords":"a","pubdate":{"$gte":20140625,"$lt":20140626}}]}`},
+		{dt, `published:'06/01/2014' AND keywords:a`, `{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-06-01T00:00:00-04:00","$lt":"2014-06-02T00:00:00-04:00"}}]}`},
+		{dt, `published>='06/01/2014 18:30' AND keywords:a`, `{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-06-01T18:30:00-04:00"}}]}`},
+		{dt, `keywords:a`, `{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-06-25T00:00:00-04:00","$lt":"2014-06-26T00:00:00-04:00"}}]}`},
+	}
+
+	for i, test := range tests {
+		query, err := parseQuery(test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := test.s.copy().buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+	}
+
+	// Layouts given to the converter override those of the MongoSearch
+	c := &Conversion{SubQuery: searchquery.SubQuery{Value: "2014-06-01"}, Layouts: []string{TimeLayout}}
+	if _, err := NewDateConverter([]string{"02/01/2006"}, nil).Convert(c); err == nil {
+		t.Error("Expected an error for a layout not configured")
+	}
+
+	// Without layouts of its own, a MongoSearch reads TimeLayout as it is now
+	defer func(layout string) { TimeLayout = layout }(TimeLayout)
+	TimeLayout = "02.01.2006"
+	query, _ := parseQuery(`published:'01.06.2014' AND keywords:a`)
+	mgoQuery, err := eu.copy().buildQuery(query)
+	if err == nil {
+		t.Errorf("Expected an error for TimeLayout with layouts configured, got %v", mgoQuery)
+	}
+	plain, _ := New("", "Items", "Results")
+	plain.SetKeyword("keywords", ConvertSpaces)
+	plain.SetPubdate("pubdate", ConvertDateInt, "published")
+	if mgoQuery, err = plain.copy().buildQuery(query); err != nil {
+		t.Fatalf("TimeLayout: buildQuery: %s", err)
+	}
+	expect := `{"$or":[{"keywords":"a","pubdate":20140601}]}`
+	if b, _ := json.Marshal(mgoQuery); string(b) != expect {
+		t.Errorf("TimeLayout: Expect: %s", expect)
+		t.Errorf("TimeLayout: Got:    %s", b)
+	}
+
+	// The window and relative dates reach a converter as times, whatever
+	// layouts it reads
+	own, _ := New("", "Items", "Results")
+	own.SetKeyword("keywords", ConvertSpaces)
+	own.SetPubdate("pubdate", NewDateConverter([]string{"01/02/2006"}, nil), "published")
+	own.SetClock(clock)
+	for input, expect := range map[string]string{
+		`keywords:a`:                            `{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-06-25T00:00:00Z","$lt":"2014-06-26T00:00:00Z"}}]}`,
+		`published:yesterday AND keywords:a`:    `{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-06-24T00:00:00Z","$lt":"2014-06-25T00:00:00Z"}}]}`,
+		`published:'06/01/2014' AND keywords:a`: `{"$or":[{"keywords":"a","pubdate":{"$gte":"2014-06-01T00:00:00Z","$lt":"2014-06-02T00:00:00Z"}}]}`,
+	} {
+		query, err := parseQuery(input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := own.copy().buildQuery(query)
+		if err != nil {
+			t.Fatalf("%s: buildQuery: %s", input, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != expect {
+			t.Errorf("%s: Expect: %s", input, expect)
+			t.Errorf("%s: Got:    %s", input, b)
+		}
+	}
+}
+
+func TestConverter(t *testing.T) {
+	// Sizes are stored in millimetres; names cover a range of them
+	sizes := FuncConverter(func(c *Conversion) (out Converted, err error) {
+		switch c.SubQuery.Value {
+		case "small":
+			return Converted{Kind: ValueRange, To: 100}, nil
+		case "medium":
+			return Converted{Kind: ValueRange, From: 100, To: 500}, nil
+		case "large":
+			return Converted{Kind: ValueRange, From: 500}, nil
+		}
+		return out, fmt.Errorf("Unknown size: %s", c.SubQuery.Value)
+	})
+	colors := FuncConverter(func(c *Conversion) (Converted, error) {
+		if c.Field != "color" {
+			return Converted{}, fmt.Errorf("Wrong field: %s", c.Field)
+		}
+		if c.SubQuery.Value == "grey" {
+			return Converted{Kind: ValueAny, Value: []string{"gray", "grey"}}, nil
+		}
+		return Converted{Value: c.SubQuery.Value}, nil
+	})
+
+	tests := []struct {
+		Input string
+		Query string
+	}{
+		{
+			`published:2014-06-01 AND keywords:a AND size:medium`,
+			`{"$or":[{"keywords":"a","pubdate":20140601,"size":{"$gte":100,"$lt":500}}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a AND size>medium`,
+			`{"$or":[{"keywords":"a","pubdate":20140601,"size":{"$gte":500}}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a AND size<=small`,
+			`{"$or":[{"keywords":"a","pubdate":20140601,"size":{"$lt":100}}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a AND colour:grey`,
+			`{"$or":[{"color":{"$in":["gray","grey"]},"keywords":"a","pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a NOT colour:grey`,
+			`{"$or":[{"$nor":[{"color":{"$in":["gray","grey"]}}],"keywords":"a","pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a AND colour:(red OR blue)`,
+			`{"$or":[{"color":{"$in":["red","blue"]},"keywords":"a","pubdate":20140601}]}`,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.Convert("size", sizes)
+		ms.Convert("color", colors)
+		ms.Rewrite("colour", "color")
+
+		query, err := parseQuery(test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+	}
+
+	ms, _ := New("", "Items", "Results")
+	ms.SetKeyword("keywords", ConvertSpaces)
+	ms.SetPubdate("pubdate", ConvertDateInt, "published")
+	ms.Convert("size", sizes)
+	for _, input := range []string{
+		`published:2014-06-01 AND keywords:a AND size:huge`,
+		`published:2014-06-01 AND keywords:a AND size>large`,
+	} {
+		query, _ := parseQuery(input)
+		if _, err := ms.buildQuery(query); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+
+	query, _ := parseQuery(`published:2014-06-01 AND keywords:a AND size:huge`)
+	_, err := ms.buildQuery(query)
+	if convErr, ok := err.(*ConversionError); !ok || convErr.Field != "size" || convErr.Value != "huge" {
+		t.Errorf("Expected a *ConversionError for size, got %#v", err)
+	}
+	if errors.Unwrap(err) == nil || !strings.Contains(errors.Unwrap(err).Error(), "Unknown size") {
+		t.Errorf("Expected the converter's error to be wrapped, got %v", errors.Unwrap(err))
+	}
+}
+
+func TestConvertScalars(t *testing.T) {
+	sections := NewEnumConverter(map[string]interface{}{"News": 1, "Opinion": 2})
+	tests := []struct {
+		Convert ConversionFunc
+		In      string
+		Out     interface{}
+	}{
+		{ConvertInt, "500", int64(500)},
+		{ConvertInt, "-12", int64(-12)},
+		{ConvertFloat, "2.5", 2.5},
+		{ConvertFloat, "1e3", 1000.0},
+		{ConvertBool, "true", true},
+		{ConvertBool, "F", false},
+		{ConvertBool, "Yes", true},
+		{sections, "news", 1},
+		{sections, "OPINION", 2},
+		{NewWhitelistConverter("en", "fr"), "FR", "fr"},
+	}
+	for i, test := range tests {
+		out, _, err := test.Convert(test.In)
+		if err != nil {
+			t.Errorf("[%d] %s: %s", i, test.In, err)
+			continue
+		}
+		if out != test.Out {
+			t.Errorf("[%d] %s: expected %#v, got %#v", i, test.In, test.Out, out)
+		}
+	}
+
+	for i, test := range []struct {
+		Convert ConversionFunc
+		In      string
+	}{
+		{ConvertInt, "2.5"},
+		{ConvertInt, "many"},
+		{ConvertFloat, "lots"},
+		{ConvertBool, "maybe"},
+		{sections, "Sports"},
+		{NewWhitelistConverter("en", "fr"), "de"},
+	} {
+		if out, _, err := test.Convert(test.In); err == nil {
+			t.Errorf("[%d] %s: expected an error, got %#v", i, test.In, out)
+		}
+	}
+}
+
+func TestBuildQueryScalars(t *testing.T) {
+	tests := []struct {
+		Input string
+		Query string
+	}{
+		{
+			`published:2014-06-01 AND keywords:a AND wordcount>500`,
+			`{"$or":[{"keywords":"a","pubdate":20140601,"wordcount":{"$gt":500}}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a AND wordcount>=500 AND wordcount<1000`,
+			`{"$or":[{"keywords":"a","pubdate":20140601,"wordcount":{"$gte":500,"$lt":1000}}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a AND score<=0.5 AND paywalled:no`,
+			`{"$or":[{"keywords":"a","paywalled":false,"pubdate":20140601,"score":{"$lte":0.5}}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a AND lang:(EN OR fr)`,
+			`{"$or":[{"keywords":"a","lang":{"$in":["en","fr"]},"pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a AND (pubid:53678fb4800b8e4c9d0002c9 OR pubid:53678ea54113de7739000214)`,
+			`{"$or":[{"keywords":"a","pubdate":20140601,"pubid":{"$in":["53678fb4800b8e4c9d0002c9","53678ea54113de7739000214"]}}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:a AND (wordcount<100 OR wordcount>1000)`,
+			`{"$or":[{"$or":[{"wordcount":{"$lt":100}},{"wordcount":{"$gt":1000}}],"keywords":"a","pubdate":20140601}]}`,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.Convert("wordcount", ConvertInt)
+		ms.Convert("score", ConvertFloat)
+		ms.Convert("paywalled", ConvertBool)
+		ms.Convert("lang", NewWhitelistConverter("en", "fr"))
+		ms.SetPubid("pubid", ConvertBsonId)
+
+		query, err := parseQuery(test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+	}
+}
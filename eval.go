@@ -0,0 +1,188 @@
+package mongosearch
+
+import (
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strings"
+)
+
+// Match reports whether an item with the given all-words array satisfies the
+// phrase and exclusion rules of query, as the map function would. Other fields
+// in the query are not considered; use it to post-filter items already
+// matched by the database.
+func (s *MongoSearch) Match(query string, all []string) (match bool, err error) {
+	q, err := parseQuery(query)
+	if err != nil {
+		return
+	}
+	if err = s.dropEmptyTerms(q); err != nil {
+		return
+	}
+	if _, err = s.expandSynonyms(q); err != nil {
+		return
+	}
+	scope, err := s.buildScope(q)
+	if err != nil {
+		return
+	}
+	return EvalScope(scope, s.normalization.normalizeAll(all), s.caseSensitive)
+}
+
+// EvalScope evaluates a scope built by buildScope against an all-words array
+// using the same rules as boolPhrases and boolResult in mapFunc
+func EvalScope(scope bson.M, all []string, caseSensitive bool) (match bool, err error) {
+	if !caseSensitive {
+		lower := make([]string, len(all))
+		for i := range all {
+			lower[i] = strings.ToLower(all[i])
+		}
+		all = lower
+	}
+	return evalScope(scope, all, caseSensitive)
+}
+
+func evalScope(scope map[string]interface{}, all []string, caseSensitive bool) (match bool, err error) {
+	match = true
+	for op, v := range scope {
+		subs, ok := v.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("Expected array for %s, got %T", op, v)
+		}
+
+		bools := make([]bool, len(subs))
+		for i, sub := range subs {
+			if bools[i], err = evalElement(sub, all, caseSensitive); err != nil {
+				return
+			}
+		}
+		if len(bools) == 0 {
+			continue
+		}
+
+		switch op {
+		case "or":
+			match = match && anyTrue(bools)
+		case "and":
+			match = match && allTrue(bools)
+		case "nor":
+			match = match && !anyTrue(bools)
+		}
+	}
+	return
+}
+
+func evalElement(v interface{}, all []string, caseSensitive bool) (bool, error) {
+	switch t := v.(type) {
+	case string:
+		if !caseSensitive {
+			t = lowerTerms(t)
+		}
+		if nr, ok, err := parseNear(t); err != nil {
+			return false, err
+		} else if ok {
+			left, err := compilePhrase(nr.left, caseSensitive)
+			if err != nil {
+				return false, err
+			}
+			right, err := compilePhrase(nr.right, caseSensitive)
+			if err != nil {
+				return false, err
+			}
+			return hasNear(left, right, nr.n, nr.ordered, all), nil
+		}
+		phrase, err := compilePhrase(strings.Split(t, " "), caseSensitive)
+		if err != nil {
+			return false, err
+		}
+		return hasPhrase(phrase, all), nil
+	case bson.M:
+		return evalScope(t, all, caseSensitive)
+	case map[string]interface{}:
+		return evalScope(t, all, caseSensitive)
+	}
+	return false, fmt.Errorf("Unexpected %T in scope: %#v", v, v)
+}
+
+// term matches a single word of a phrase
+type term func(word string) bool
+
+// lowerTerms lowercases the words of a phrase other than regular expression
+// terms, which are matched ignoring case instead
+func lowerTerms(phrase string) string {
+	words := strings.Split(phrase, " ")
+	for i, word := range words {
+		if !isRegexTerm(word) {
+			words[i] = strings.ToLower(word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// compilePhrase turns the words of a phrase into terms, handling wildcard and
+// regular expression terms as wordMatch in mapFunc does
+func compilePhrase(words []string, caseSensitive bool) (phrase []term, err error) {
+	phrase = make([]term, len(words))
+	for i, word := range words {
+		word := word
+		if !isPattern(word) {
+			phrase[i] = func(w string) bool { return w == word }
+			continue
+		}
+		pattern, _, err := termPattern(word)
+		if err != nil {
+			return nil, err
+		}
+		if !caseSensitive {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		phrase[i] = re.MatchString
+	}
+	return
+}
+
+// hasPhrase mirrors hasPhrase in mapFunc, trying every occurrence of the
+// phrase's first word
+func hasPhrase(phrase []term, all []string) bool {
+	for i := range all {
+		if phraseAt(phrase, all, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// phraseAt reports whether phrase appears in all starting at i
+func phraseAt(phrase []term, all []string, i int) bool {
+	if i+len(phrase) > len(all) {
+		return false
+	}
+	for a := range phrase {
+		if !phrase[a](all[i+a]) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyTrue(bools []bool) bool {
+	for _, b := range bools {
+		if b {
+			return true
+		}
+	}
+	return false
+}
+
+func allTrue(bools []bool) bool {
+	for _, b := range bools {
+		if !b {
+			return false
+		}
+	}
+	return true
+}
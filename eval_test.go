@@ -0,0 +1,71 @@
+package mongosearch
+
+import (
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"testing"
+)
+
+func TestEvalScope(t *testing.T) {
+	all := strings.Fields("The data center and the Google data room b c")
+	tests := []struct {
+		Scope         bson.M
+		CaseSensitive bool
+		Match         bool
+	}{
+		{bson.M{}, false, true},
+		{bson.M{"and": []interface{}{}}, false, true},
+		{bson.M{"and": []interface{}{"data center"}}, false, true},
+		{bson.M{"and": []interface{}{"center data"}}, false, false},
+		{bson.M{"and": []interface{}{"data center", "google"}}, false, true},
+		{bson.M{"and": []interface{}{"data center", "google"}}, true, false},
+		{bson.M{"and": []interface{}{"data center", "Google"}}, true, true},
+		{bson.M{"or": []interface{}{"cloud", "google"}}, false, true},
+		{bson.M{"or": []interface{}{"cloud", "amazon"}}, false, false},
+		{bson.M{"nor": []interface{}{"cloud", "amazon"}}, false, true},
+		{bson.M{"nor": []interface{}{"cloud", "data"}}, false, false},
+		{
+			bson.M{
+				"and": []interface{}{bson.M{"or": []interface{}{"cdw", "google"}}},
+				"nor": []interface{}{bson.M{"and": []interface{}{"collision damage waiver"}}},
+			},
+			false,
+			true,
+		},
+		{
+			bson.M{
+				"or": []interface{}{
+					bson.M{"and": []interface{}{"data center", "amazon"}},
+					bson.M{"and": []interface{}{"data center", "google"}},
+				},
+			},
+			false,
+			true,
+		},
+		// Phrases spanning the whole text, ending it, or found only at a
+		// later occurrence of their first word
+		{bson.M{"and": []interface{}{"b c"}}, false, true},
+		{bson.M{"and": []interface{}{"the data center and the google data room b c"}}, false, true},
+		{bson.M{"and": []interface{}{"data room"}}, false, true},
+		{bson.M{"and": []interface{}{"room b c d"}}, false, false},
+	}
+
+	for i, test := range tests {
+		match, err := EvalScope(test.Scope, all, test.CaseSensitive)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if match != test.Match {
+			t.Errorf("[%d] %v: expected %v", i, test.Scope, test.Match)
+		}
+	}
+}
+
+func TestEvalScopeInvalid(t *testing.T) {
+	if _, err := EvalScope(bson.M{"and": "a"}, nil, false); err == nil {
+		t.Error("Expected error for non-array operator")
+	}
+	if _, err := EvalScope(bson.M{"and": []interface{}{1}}, nil, false); err == nil {
+		t.Error("Expected error for non-string element")
+	}
+}
@@ -1,33 +1,117 @@
 package mongosearch
 
-var mapFuncImmediate = `function() { emit(this._id, {}) }`
+var mapFuncImmediate = `function() { emit(this._id, { pubdate: this.%s }) }`
 
 var mapFunc = `
 function() {
+	// Tests a word against a term of a phrase. Terms may hold wildcards (*
+	// and ?) or be written as a /regular expression/
+	var patterns = {}
+	var isRegex = function(term) {
+		return term.length > 2 && term[0] == "/" && term[term.length - 1] == "/"
+	}
+	var wordMatch = function(term, word) {
+		var regex = isRegex(term)
+		if (!regex && term.indexOf("*") == -1 && term.indexOf("?") == -1) {
+			return term == word
+		}
+		if (!(term in patterns)) {
+			var src = "^(?:" + term.slice(1, -1) + ")$"
+			if (!regex) {
+				src = "^" + term.replace(/[.+^${}()|[\]\\]/g, "\\$&").replace(/\*/g, ".*").replace(/\?/g, ".") + "$"
+			}
+			patterns[term] = new RegExp(src, caseSensitive ? "" : "i")
+		}
+		return patterns[term].test(word)
+	}
+
+	// Lowercases every phrase in a scope, leaving regular expression terms
+	// alone as they are matched ignoring case instead
+	var lowerTerms = function(o) {
+		if (typeof o == "string") {
+			var words = o.split(/ /)
+			for (var i = 0; i < words.length; i++) {
+				if (!isRegex(words[i])) {
+					words[i] = words[i].toLowerCase()
+				}
+			}
+			return words.join(" ")
+		}
+		var out = o instanceof Array ? [] : {}
+		for (var k in o) {
+			out[k] = lowerTerms(o[k])
+		}
+		return out
+	}
+
+	// Finds the first word at or after from matching term
+	var indexOf = function(all, term, from) {
+		for (var j = from; j < all.length; j++) {
+			if (wordMatch(term, all[j])) {
+				return j
+			}
+		}
+		return -1
+	}
+
+	// Checks whether phrase appears in all starting at i
+	var phraseAt = function(phrase, all, i) {
+		if (i + phrase.length > all.length) {
+			return false
+		}
+		for (var a = 0; a < phrase.length; a++) {
+			if (!wordMatch(phrase[a], all[i + a])) {
+				return false
+			}
+		}
+		return true
+	}
+
 	// Checks to see if the phrase (array of single words) exists in the
-	// all-words array of the object
+	// all-words array of the object. Every occurrence of the first word is
+	// tried, up to and including the end of the text.
 	var hasPhrase = function(phrase, all) {
-		var phraseLen = phrase.length
-		for (var i = 0; i + phraseLen < all.length; i++) {
-			// See if phrase exists at all
-			idx = all.indexOf(phrase[0], i)
-			if (idx == -1) {
-				return false
+		for (var i = indexOf(all, phrase[0], 0); i != -1; i = indexOf(all, phrase[0], i + 1)) {
+			if (phraseAt(phrase, all, i)) {
+				return true
 			}
-			i += idx
+		}
+		return false
+	}
 
-			// Check to see if the phrase matches
-			var slice = all.slice(i, i + phraseLen)
-			for (var a = 0; a < phraseLen; a++) {
-				if (slice[a] != phrase[a]) {
-					return false
+	// Checks for left and right with at most n words between them; when
+	// ordered, left must come first
+	var hasNear = function(left, right, n, ordered, all) {
+		for (var i = 0; i < all.length; i++) {
+			if (!phraseAt(left, all, i)) {
+				continue
+			}
+			var from = Math.max(0, i - n - right.length)
+			for (var j = from; j <= i + left.length + n && j < all.length; j++) {
+				if (!phraseAt(right, all, j)) {
+					continue
+				}
+				if (j >= i + left.length) {
+					return true
+				}
+				if (!ordered && i >= j + right.length) {
+					return true
 				}
 			}
-			return true
 		}
 		return false
 	}
 
+	// Tests a phrase, which may hold a NEAR/n or ONEAR/n operator
+	var nearExpr = /^(.+?) (o?near)\/(\d+) (.+)$/i
+	var matchPhrase = function(v, all) {
+		var m = nearExpr.exec(v)
+		if (m == null) {
+			return hasPhrase(v.split(/ /), all)
+		}
+		return hasNear(m[1].split(/ /), m[4].split(/ /), parseInt(m[3], 10), m[2].toLowerCase() == "onear", all)
+	}
+
 	// Walks over every phrase and tests its presence in the all-words array
 	var boolPhrases = function(o, text) {
 		for (var k in o) {
@@ -35,7 +119,7 @@ function() {
 				var v = o[k][i]
 				switch (typeof v) {
 				case "string":
-					o[k][i] = hasPhrase(v.split(/ /), text)
+					o[k][i] = matchPhrase(v, text)
 					break
 				case "object":
 					boolPhrases(o[k][i], text)
@@ -94,11 +178,39 @@ function() {
 		return ret
 	}
 
+	// Replaces characters as given by the normalize list of characters and
+	// their replacements, splitting words where spaces are introduced
+	var normalizeWords = function(all) {
+		var table = {}
+		for (var i = 0; i + 1 < normalize.length; i += 2) {
+			table[normalize[i]] = normalize[i + 1]
+		}
+		var out = []
+		for (var i = 0; i < all.length; i++) {
+			var word = ""
+			for (var c = 0; c < all[i].length; c++) {
+				var ch = all[i].charAt(c)
+				word += table.hasOwnProperty(ch) ? table[ch] : ch
+			}
+			var parts = word.split(/\s+/)
+			for (var p = 0; p < parts.length; p++) {
+				if (parts[p] != "") {
+					out.push(parts[p])
+				}
+			}
+		}
+		return out
+	}
+
 	// Put the funcs to good use
-	var all = this.%s
+	var all = this.%[1]s
+	if (normalize && normalize.length) {
+		all = normalizeWords(all)
+	}
 
 	var o = {
-		query: query
+		query: query,
+		pubdate: this.%[2]s
 	}
 
 	if (caseSensitive) {
@@ -108,7 +220,7 @@ function() {
 		for (var i = 0; i < all.length; i++) {
 			all[i] = all[i].toLowerCase()
 		}
-		o.result = JSON.parse(JSON.stringify(query).toLowerCase())
+		o.result = lowerTerms(query)
 	}
 
 	boolPhrases(o.result, all)
@@ -0,0 +1,331 @@
+package mongosearch
+
+import (
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// matchFilter reports whether doc satisfies a Mongo query filter. Only the
+// operators produced by buildQuery and used on metadata documents are
+// supported.
+func matchFilter(doc bson.M, filter bson.M) (match bool, err error) {
+	for key, cond := range filter {
+		switch key {
+		case "$and", "$or", "$nor":
+			subs, ok := toList(cond)
+			if !ok {
+				return false, fmt.Errorf("Expected array for %s, got %T", key, cond)
+			}
+			matched := 0
+			for _, sub := range subs {
+				subFilter, ok := toDoc(sub)
+				if !ok {
+					return false, fmt.Errorf("Expected document in %s, got %T", key, sub)
+				}
+				ok, err := matchFilter(doc, subFilter)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					matched++
+				}
+			}
+			switch key {
+			case "$and":
+				match = matched == len(subs)
+			case "$or":
+				match = matched > 0
+			case "$nor":
+				match = matched == 0
+			}
+		default:
+			if match, err = matchField(doc, key, cond); err != nil {
+				return
+			}
+		}
+		if !match {
+			return
+		}
+	}
+	return true, nil
+}
+
+// matchField checks the value at path in doc against cond, which is either a
+// value to compare with or a document of operators
+func matchField(doc bson.M, path string, cond interface{}) (match bool, err error) {
+	value, found := lookup(doc, path)
+
+	ops, ok := toDoc(cond)
+	if !ok || !isOperatorDoc(ops) {
+		return matchEqual(value, cond), nil
+	}
+
+	for op, arg := range ops {
+		switch op {
+		case "$eq":
+			match = matchEqual(value, arg)
+		case "$ne":
+			match = !matchEqual(value, arg)
+		case "$in", "$nin", "$all":
+			args, ok := toList(arg)
+			if !ok {
+				return false, fmt.Errorf("Expected array for %s, got %T", op, arg)
+			}
+			matched := 0
+			for _, a := range args {
+				if matchEqual(value, a) {
+					matched++
+				}
+			}
+			switch op {
+			case "$in":
+				match = matched > 0
+			case "$nin":
+				match = matched == 0
+			case "$all":
+				match = len(args) > 0 && matched == len(args)
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			match = matchAny(value, func(v interface{}) bool {
+				cmp, ok := compareValues(v, arg)
+				switch {
+				case !ok:
+					return false
+				case op == "$gt":
+					return cmp > 0
+				case op == "$gte":
+					return cmp >= 0
+				case op == "$lt":
+					return cmp < 0
+				}
+				return cmp <= 0
+			})
+		case "$regex":
+			pattern, _ := arg.(string)
+			if options, _ := ops["$options"].(string); options != "" {
+				pattern = "(?" + options + ")" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return false, err
+			}
+			match = matchAny(value, func(v interface{}) bool {
+				str, ok := v.(string)
+				return ok && re.MatchString(str)
+			})
+		case "$options":
+			match = true
+		case "$not":
+			if match, err = matchField(doc, path, arg); err != nil {
+				return
+			}
+			match = !match
+		case "$exists":
+			want, _ := arg.(bool)
+			match = found == want
+		default:
+			return false, fmt.Errorf("Unsupported operator %s", op)
+		}
+		if !match {
+			return
+		}
+	}
+	return true, nil
+}
+
+// matchEqual compares value with target; arrays match if any element does
+func matchEqual(value, target interface{}) bool {
+	if valuesEqual(value, target) {
+		return true
+	}
+	list, ok := toList(value)
+	if !ok {
+		return false
+	}
+	for _, v := range list {
+		if valuesEqual(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny calls f with value, or with each element if value is an array
+func matchAny(value interface{}, f func(interface{}) bool) bool {
+	list, ok := toList(value)
+	if !ok {
+		return f(value)
+	}
+	for _, v := range list {
+		if f(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if re, ok := b.(bson.RegEx); ok {
+		str, ok := a.(string)
+		return ok && regexMatch(re, str)
+	}
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// regexMatch reports whether str matches re. Invalid expressions never match.
+func regexMatch(re bson.RegEx, str string) bool {
+	pattern := re.Pattern
+	if re.Options != "" {
+		pattern = "(?" + re.Options + ")" + pattern
+	}
+	matched, err := regexp.MatchString(pattern, str)
+	return err == nil && matched
+}
+
+// compareValues orders two values of the same BSON type. ok is false if the
+// values cannot be compared.
+func compareValues(a, b interface{}) (cmp int, ok bool) {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bson.ObjectId:
+		if y, ok := b.(bson.ObjectId); ok {
+			return strings.Compare(string(x), string(y)), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1, true
+			case x.After(y):
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (f float64, ok bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// toList converts any slice, other than []byte, to []interface{}
+func toList(v interface{}) (list []interface{}, ok bool) {
+	if list, ok = v.([]interface{}); ok {
+		return
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	list = make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
+
+func toDoc(v interface{}) (doc bson.M, ok bool) {
+	switch t := v.(type) {
+	case bson.M:
+		return t, true
+	case map[string]interface{}:
+		return bson.M(t), true
+	}
+	return nil, false
+}
+
+func isOperatorDoc(doc bson.M) bool {
+	if len(doc) == 0 {
+		return false
+	}
+	for k := range doc {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup finds the value at a dotted path in doc. Arrays of documents along
+// the way are searched element by element, collecting every value found.
+func lookup(doc bson.M, path string) (value interface{}, found bool) {
+	name, rest := path, ""
+	if i := strings.Index(path, "."); i >= 0 {
+		name, rest = path[:i], path[i+1:]
+	}
+
+	value, found = doc[name]
+	if !found || rest == "" {
+		return
+	}
+
+	if sub, ok := toDoc(value); ok {
+		return lookup(sub, rest)
+	}
+	list, ok := toList(value)
+	if !ok {
+		return nil, false
+	}
+	var values []interface{}
+	for _, v := range list {
+		sub, ok := toDoc(v)
+		if !ok {
+			continue
+		}
+		if v, ok := lookup(sub, rest); ok {
+			if vs, ok := toList(v); ok {
+				values = append(values, vs...)
+			} else {
+				values = append(values, v)
+			}
+		}
+	}
+	return values, len(values) > 0
+}
@@ -0,0 +1,59 @@
+package mongosearch
+
+import (
+	"labix.org/v2/mgo/bson"
+	"testing"
+	"time"
+)
+
+func TestMatchFilter(t *testing.T) {
+	date := time.Date(2014, 6, 2, 0, 0, 0, 0, time.UTC)
+	doc := bson.M{
+		"_id":      bson.ObjectIdHex("53a803bffc16f879a2000001"),
+		"date":     date,
+		"intdate":  20140602,
+		"keywords": []interface{}{"data", "center"},
+		"text":     bson.M{"words": bson.M{"all": []interface{}{"the", "data"}}},
+		"authors":  []interface{}{bson.M{"name": "a"}, bson.M{"name": "b"}},
+	}
+
+	tests := []struct {
+		Filter bson.M
+		Match  bool
+	}{
+		{bson.M{}, true},
+		{bson.M{"keywords": "data"}, true},
+		{bson.M{"keywords": "cloud"}, false},
+		{bson.M{"keywords": bson.M{"$all": []string{"data", "center"}}}, true},
+		{bson.M{"keywords": bson.M{"$all": []string{"data", "cloud"}}}, false},
+		{bson.M{"keywords": bson.M{"$in": []interface{}{"cloud", "center"}}}, true},
+		{bson.M{"keywords": bson.M{"$nin": []interface{}{"cloud", "center"}}}, false},
+		{bson.M{"keywords": bson.M{"$ne": "cloud"}}, true},
+		{bson.M{"date": date.In(time.Local)}, true},
+		{bson.M{"date": bson.M{"$gte": date, "$lt": date.AddDate(0, 0, 1)}}, true},
+		{bson.M{"date": bson.M{"$gt": date}}, false},
+		{bson.M{"intdate": int64(20140602)}, true},
+		{bson.M{"intdate": bson.M{"$lte": 20140601}}, false},
+		{bson.M{"text.words.all": "the"}, true},
+		{bson.M{"authors.name": "b"}, true},
+		{bson.M{"missing": bson.M{"$exists": false}}, true},
+		{bson.M{"_id": bson.M{"$lt": bson.ObjectIdHex("53a803bffc16f879a2000002")}}, true},
+		{bson.M{"$or": []bson.M{{"keywords": "cloud"}, {"intdate": 20140602}}}, true},
+		{bson.M{"$and": []bson.M{{"keywords": "cloud"}, {"intdate": 20140602}}}, false},
+		{bson.M{"$nor": []bson.M{{"keywords": "cloud"}}}, true},
+	}
+
+	for i, test := range tests {
+		match, err := matchFilter(doc, test.Filter)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if match != test.Match {
+			t.Errorf("[%d] %v: expected %v", i, test.Filter, test.Match)
+		}
+	}
+
+	if _, err := matchFilter(doc, bson.M{"keywords": bson.M{"$where": "1"}}); err == nil {
+		t.Error("Expected error for unsupported operator")
+	}
+}
@@ -0,0 +1,267 @@
+package mongosearch
+
+import (
+	"context"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore is a Store keeping items, metadata and results in memory. It
+// evaluates queries and scopes in Go, so the full search path can be run
+// without a database; handy for tests.
+type MemoryStore struct {
+	sync.Mutex
+	items   []bson.M
+	meta    map[bson.ObjectId]bson.M
+	results map[bson.ObjectId][]bson.M
+}
+
+// docIter walks over a slice of documents
+type docIter struct {
+	docs []bson.M
+	err  error
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		meta:    make(map[bson.ObjectId]bson.M),
+		results: make(map[bson.ObjectId][]bson.M),
+	}
+}
+
+// Insert adds items to be searched. Items are stored as mgo would store them;
+// those without an _id are given a new ObjectId.
+func (m *MemoryStore) Insert(items ...interface{}) (err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, item := range items {
+		var doc bson.M
+		if err = roundTrip(item, &doc); err != nil {
+			return
+		}
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = bson.NewObjectId()
+		}
+		m.items = append(m.items, doc)
+	}
+	return
+}
+
+func (m *MemoryStore) Execute(ctx context.Context, job *Job) (info interface{}, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	mri := new(mgo.MapReduceInfo)
+	results := []bson.M{}
+	for _, item := range m.items {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		match, err := matchFilter(item, job.Query)
+		if err != nil {
+			return nil, err
+		}
+		if !match {
+			continue
+		}
+		mri.InputCount++
+
+		if job.MapReduce {
+			value, _ := lookup(item, job.All)
+			list, _ := toList(value)
+			all := make([]string, 0, len(list))
+			for _, v := range list {
+				if word, ok := v.(string); ok {
+					all = append(all, word)
+				}
+			}
+			all = job.Normalization.normalizeAll(all)
+			if match, err = EvalScope(job.Scope, all, job.CaseSensitive); err != nil {
+				return nil, err
+			}
+			if !match {
+				continue
+			}
+		}
+		mri.EmitCount++
+
+		pubdate, _ := lookup(item, job.Pubdate)
+		results = append(results, bson.M{
+			"_id": item["_id"],
+			"value": bson.M{
+				"pubdate": pubdate,
+			},
+		})
+	}
+
+	mri.OutputCount = len(results)
+	m.results[job.Id] = results
+	return mri, nil
+}
+
+func (m *MemoryStore) SetMeta(id bson.ObjectId, set bson.M) (err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	doc, ok := m.meta[id]
+	if !ok {
+		doc = bson.M{"_id": id}
+	}
+	for key, value := range set {
+		var v struct{ V interface{} }
+		if err = roundTrip(bson.M{"v": value}, &v); err != nil {
+			return
+		}
+
+		// Create or descend into subdocuments for dotted keys
+		d, names := doc, strings.Split(key, ".")
+		for _, name := range names[:len(names)-1] {
+			sub, ok := toDoc(d[name])
+			if !ok {
+				sub = bson.M{}
+				d[name] = sub
+			}
+			d = sub
+		}
+		d[names[len(names)-1]] = v.V
+	}
+	m.meta[id] = doc
+	return
+}
+
+func (m *MemoryStore) Meta(id bson.ObjectId, result interface{}) (err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	doc, ok := m.meta[id]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	return roundTrip(doc, result)
+}
+
+func (m *MemoryStore) Searches(filter bson.M, skip int, result interface{}) (err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	docs := []bson.M{}
+	for _, doc := range m.meta {
+		match, err := matchFilter(doc, filter)
+		if err != nil {
+			return err
+		}
+		if match {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i]["_id"].(bson.ObjectId) > docs[j]["_id"].(bson.ObjectId)
+	})
+	if skip >= len(docs) {
+		docs = docs[:0]
+	} else {
+		docs = docs[skip:]
+	}
+
+	var wrapper struct{ Docs bson.Raw }
+	if err = roundTrip(bson.M{"docs": docs}, &wrapper); err != nil {
+		return
+	}
+	return wrapper.Docs.Unmarshal(result)
+}
+
+func (m *MemoryStore) Results(id bson.ObjectId, opts ResultOptions) (it Iter, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	docs := make([]bson.M, len(m.results[id]))
+	copy(docs, m.results[id])
+
+	if opts.Sort != 0 {
+		sort.SliceStable(docs, func(i, j int) bool {
+			a, _ := lookup(docs[i], "value.pubdate")
+			b, _ := lookup(docs[j], "value.pubdate")
+			cmp, _ := compareValues(a, b)
+			return cmp*opts.Sort < 0
+		})
+	}
+	if opts.Skip >= len(docs) {
+		docs = docs[:0]
+	} else if opts.Skip > 0 {
+		docs = docs[opts.Skip:]
+	}
+	if opts.Limit > 0 && opts.Limit < len(docs) {
+		docs = docs[:opts.Limit]
+	}
+
+	ids := make([]bson.M, len(docs))
+	for i := range docs {
+		ids[i] = bson.M{"_id": docs[i]["_id"]}
+	}
+	return &docIter{docs: ids}, nil
+}
+
+func (m *MemoryStore) ResultCount(id bson.ObjectId) (n int, err error) {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.results[id]), nil
+}
+
+func (m *MemoryStore) Items(ids []interface{}) (items []bson.Raw, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, item := range m.items {
+		if !matchEqual(ids, item["_id"]) {
+			continue
+		}
+		data, err := bson.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, bson.Raw{Kind: 0x03, Data: data})
+	}
+	return
+}
+
+func (m *MemoryStore) Drop(id bson.ObjectId) (err error) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.results, id)
+	return
+}
+
+func (m *MemoryStore) Close() {}
+
+func (it *docIter) Next(result interface{}) bool {
+	if it.err != nil || len(it.docs) == 0 {
+		return false
+	}
+	doc := it.docs[0]
+	it.docs = it.docs[1:]
+	it.err = roundTrip(doc, result)
+	return it.err == nil
+}
+
+func (it *docIter) Err() error {
+	return it.err
+}
+
+func (it *docIter) Close() error {
+	return it.err
+}
+
+// roundTrip marshals in to BSON and back into out, normalizing types the way
+// a trip through the database would
+func roundTrip(in, out interface{}) (err error) {
+	data, err := bson.Marshal(in)
+	if err != nil {
+		return
+	}
+	return bson.Unmarshal(data, out)
+}
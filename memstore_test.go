@@ -0,0 +1,232 @@
+package mongosearch
+
+import (
+	"context"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memDoc struct {
+	Id    int           `bson:"_id"`
+	PubId bson.ObjectId `bson:"pubid"`
+	Date  time.Time     `bson:"date"`
+	All   []string      `bson:"all"`
+	Kws   []string      `bson:"keywords"`
+	Count int           `bson:"wordcount"`
+}
+
+func newMemorySearch(t *testing.T) (s *MongoSearch, store *MemoryStore) {
+	newDoc := func(id int, d string, text string) memDoc {
+		t, _ := time.Parse("2006-01-02", d)
+		return memDoc{
+			Id:    id,
+			PubId: pubs[id%len(pubs)],
+			Date:  t,
+			All:   strings.Fields(text),
+			Kws:   strings.Fields(text),
+			Count: len(strings.Fields(text)),
+		}
+	}
+
+	store = NewMemoryStore()
+	if err := store.Insert(
+		newDoc(1, "2014-06-01", "a 0 b 1 c 2 d"),
+		newDoc(2, "2014-06-02", "a 0 1 b 0 c d e 2 f"),
+		newDoc(3, "2014-06-02", "a 1 2 b 0 c e 2 g"),
+		newDoc(4, "2014-06-03", "0 1 b 0 2"),
+		newDoc(5, "2014-06-04", "a a b b c c"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewWithStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+	s.Convert("wordcount", ConvertInt)
+	return
+}
+
+func memResults(t *testing.T, s *MongoSearch, id bson.ObjectId, opts ResultOptions) (ids []int) {
+	it, err := s.Results(id, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc memDoc
+	for it.Next(&doc) {
+		ids = append(ids, doc.Id)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestMemoryStoreSearch(t *testing.T) {
+	tests := []struct {
+		Query string
+		Ids   []int
+	}{
+		{`date:2014-06-02 AND keywords:(a OR e)`, []int{2, 3}},
+		{`date:2014-06-03 AND keywords:b`, []int{4}},
+		{`date:2014-06-02 AND keywords:"a 0"`, []int{2}},
+		{`date:2014-06-02 AND keywords:(a NOT "a 1")`, []int{2}},
+		{`date:2014-06-02 AND keywords:(a NOT e)`, nil},
+		{`date:2014-06-02 AND keywords:(a NOT (f OR g))`, nil},
+		{`date:2014-06-02 AND keywords:(c NOT g)`, []int{2}},
+		{`date:2014-06-02 AND keywords:(c NOT G)`, []int{2}},
+		{`date:2014-06-02 AND keywords:a NOT keywords:E`, nil},
+		{`date:2014-06-02 AND keywords:a NOT pubid:300000000000000000000000`, []int{3}},
+		{`date:2014-06-02 AND keywords:(a NOT "0 1") NOT pubid:300000000000000000000000`, []int{3}},
+		{`date:2014-06-02 AND keywords:(a NOT "0 1") NOT pubid:100000000000000000000000`, nil},
+		{`date:(2014-06-01 OR 2014-06-02) AND keywords:a NOT date:2014-06-02`, []int{1}},
+		{`date:[2014-06-02 TO 2014-06-03] AND keywords:b`, []int{2, 3, 4}},
+		{`date:2014-06-02 AND ((keywords:a NOT keywords:g) OR keywords:zzz)`, []int{2}},
+		{`date>=2014-06-03 AND keywords:b`, []int{4, 5}},
+		{`date:yesterday AND keywords:b`, []int{2, 3}},
+		{`date>=now-1d AND keywords:b NOT date:today`, []int{2, 3, 5}},
+		{`date:2014-06-02 AND keywords:a AND wordcount>9`, []int{2}},
+		{`date>=2014-06-01 AND keywords:b AND wordcount<=6`, []int{4, 5}},
+		{`date:2014-06-02 AND keywords:/[fg]/`, []int{2, 3}},
+		{`date:2014-06-02 AND keywords:(a NOT g*)`, []int{2}},
+		{`date:2014-06-02 AND keywords:"0 ? b"`, []int{2}},
+		{`date:2014-06-02 AND keywords:"a NEAR/2 b"`, []int{2, 3}},
+		{`date:2014-06-02 AND keywords:"a NEAR/1 b"`, nil},
+		{`date:2014-06-02 AND keywords:"e ONEAR/1 2"`, []int{2, 3}},
+		{`date:2014-06-02 AND keywords:"2 ONEAR/1 e"`, nil},
+		{`date:2014-06-02 AND keywords:"2 NEAR/0 e"`, []int{2, 3}},
+		{`date:2014-06-01 AND keywords:z`, nil},
+	}
+
+	s, _ := newMemorySearch(t)
+	s.SetClock(func() time.Time {
+		return time.Date(2014, 6, 3, 12, 0, 0, 0, time.UTC)
+	})
+	for _, test := range tests {
+		id, err := s.Search(test.Query)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Query, err)
+		}
+		if ids := memResults(t, s, id, ResultOptions{}); !reflect.DeepEqual(ids, test.Ids) {
+			t.Errorf("%s: expected %v, got %v", test.Query, test.Ids, ids)
+		}
+		if status, err := s.Status(id); err != nil || status.Status != StatusDone {
+			t.Errorf("%s: expected status %s, got %+v (%v)", test.Query, StatusDone, status, err)
+		}
+	}
+}
+
+func TestMemoryStoreResults(t *testing.T) {
+	s, _ := newMemorySearch(t)
+	id, err := s.Search(`date:("2014-06-01" OR "2014-06-02" OR "2014-06-04") AND keywords:a`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := memResults(t, s, id, ResultOptions{Sort: -1, Skip: 1, Limit: 2, Hydrate: true}); !reflect.DeepEqual(ids, []int{2, 3}) {
+		t.Errorf("Expected [2 3], got %v", ids)
+	}
+
+	it, err := s.Results(id, ResultOptions{Hydrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	if n, err := it.Count(); err != nil || n != 4 {
+		t.Errorf("Expected 4 results, got %d (%v)", n, err)
+	}
+	var doc memDoc
+	if !it.Next(&doc) || len(doc.All) == 0 {
+		t.Errorf("Expected hydrated document, got %+v (%v)", doc, it.Err())
+	}
+}
+
+func TestMemoryStoreAsyncAndPurge(t *testing.T) {
+	s, _ := newMemorySearch(t)
+	s.SetPollInterval(time.Millisecond)
+
+	id, err := s.SearchAsync(`date:2014-06-02 AND keywords:a`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if status, err := s.Wait(ctx, id); err != nil || status.Status != StatusDone {
+		t.Fatalf("Expected status %s, got %+v (%v)", StatusDone, status, err)
+	}
+
+	failed, err := s.Search(`date:not-a-date AND keywords:a`)
+	if err == nil {
+		t.Fatal("Expected an error converting the date")
+	}
+	status, err := s.Status(failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != StatusFailed || status.Error == "" {
+		t.Errorf("Expected failure to be recorded, got %+v", status)
+	}
+
+	if n, err := s.Purge(time.Now().Add(time.Minute)); err != nil || n != 2 {
+		t.Fatalf("Expected 2 searches purged, got %d (%v)", n, err)
+	}
+	if status, _ := s.Status(id); status.Status != StatusExpired {
+		t.Errorf("Expected status %s, got %s", StatusExpired, status.Status)
+	}
+	if ids := memResults(t, s, id, ResultOptions{}); len(ids) != 0 {
+		t.Errorf("Expected results to be dropped, got %v", ids)
+	}
+}
+
+// blockingStore holds searches until they are cancelled
+type blockingStore struct {
+	*MemoryStore
+	started chan struct{}
+}
+
+func (b *blockingStore) Execute(ctx context.Context, job *Job) (info interface{}, err error) {
+	close(b.started)
+	<-ctx.Done()
+	return b.MemoryStore.Execute(ctx, job)
+}
+
+func TestMemoryStoreCancel(t *testing.T) {
+	_, store := newMemorySearch(t)
+	blocking := &blockingStore{MemoryStore: store, started: make(chan struct{})}
+	s, err := NewWithStore(blocking)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-blocking.started
+		cancel()
+	}()
+
+	id := bson.NewObjectId()
+	if err := s.SearchIntoContext(ctx, `date:2014-06-02 AND keywords:a`, id); err != context.Canceled {
+		t.Fatalf("Expected %s, got %v", context.Canceled, err)
+	}
+	status, err := s.Status(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != StatusCancelled {
+		t.Errorf("Expected status %s, got %s", StatusCancelled, status.Status)
+	}
+	if ids := memResults(t, s, id, ResultOptions{}); len(ids) != 0 {
+		t.Errorf("Expected no results, got %v", ids)
+	}
+}
@@ -0,0 +1,369 @@
+package mongosearch
+
+import (
+	"context"
+	"fmt"
+	"github.com/300brand/logger"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+// mgoStore is the default Store, keeping items, metadata and results in the
+// collections named by the MongoSearch
+type mgoStore struct {
+	s    *MongoSearch
+	kill func(id bson.ObjectId) (killed bool, err error) // killOp if nil
+}
+
+// killAttempts limits how many times a cancelled search is looked for on the
+// server before it is left to finish on its own
+const killAttempts = 5
+
+// mgoIter gives back its session once closed. Counting and hydrating results
+// use the same session, so an open iterator never waits on the pool.
+type mgoIter struct {
+	*mgo.Iter
+	m       *mgoStore
+	session *mgo.Session
+	id      bson.ObjectId
+}
+
+func (it *mgoIter) Count() (n int, err error) {
+	return it.m.resultCount(it.session, it.id)
+}
+
+func (it *mgoIter) Items(ids []interface{}) (items []bson.Raw, err error) {
+	return it.m.items(it.session, ids)
+}
+
+func (it *mgoIter) Close() (err error) {
+	err = it.Iter.Close()
+	it.m.s.releaseSession(it.session)
+	return
+}
+
+// mapReduceResult is the outcome of an operation run by Execute
+type mapReduceResult struct {
+	info *mgo.MapReduceInfo
+	err  error
+}
+
+func (m *mgoStore) Execute(ctx context.Context, job *Job) (info interface{}, err error) {
+	session, err := m.s.copySession(ctx)
+	if err != nil {
+		return
+	}
+	defer m.s.releaseSession(session)
+
+	done := make(chan mapReduceResult, 1)
+	go func() {
+		var r mapReduceResult
+		if job.Backend == BackendAggregate && !job.MapReduce {
+			r.info, r.err = m.doAggregate(session, job)
+		} else {
+			r.info, r.err = m.doMapReduce(session, job)
+		}
+		done <- r
+	}()
+
+	progress := time.NewTicker(m.s.pollInterval)
+	defer progress.Stop()
+
+	for {
+		select {
+		case r := <-done:
+			if r.err != nil {
+				return nil, r.err
+			}
+			return r.info, nil
+		case <-progress.C:
+			if err := m.recordProgress(job.Id); err != nil {
+				logger.Warn.Printf("Recording progress for %s: %s", job.Id.Hex(), err)
+			}
+		case <-ctx.Done():
+			m.cancel(job.Id, done)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// cancel kills the operation running search id, retrying as it may not have
+// reached the server yet. Gives up after killAttempts, or at once if killing
+// is not allowed, rather than holding up the caller until the operation
+// finishes; the session is then closed out from under it.
+func (m *mgoStore) cancel(id bson.ObjectId, done <-chan mapReduceResult) {
+	kill := m.kill
+	if kill == nil {
+		kill = m.killOp
+	}
+	retry := time.NewTicker(m.s.pollInterval)
+	defer retry.Stop()
+
+	for attempt := 1; ; attempt++ {
+		killed, err := kill(id)
+		if isUnauthorized(err) {
+			logger.Warn.Printf("Killing %s: %s; leaving it to finish", id.Hex(), err)
+			return
+		}
+		if err != nil {
+			logger.Warn.Printf("Killing %s: %s", id.Hex(), err)
+		}
+		if killed || attempt >= killAttempts {
+			break
+		}
+		select {
+		case <-done:
+			return
+		case <-retry.C:
+		}
+	}
+
+	// Once killed, the operation returns promptly
+	select {
+	case <-done:
+	case <-retry.C:
+	}
+}
+
+// isUnauthorized reports whether err is the server refusing a command for
+// lack of privileges
+func isUnauthorized(err error) bool {
+	qerr, ok := err.(*mgo.QueryError)
+	return ok && (qerr.Code == 13 || strings.Contains(qerr.Message, "not authorized"))
+}
+
+func (m *mgoStore) SetMeta(id bson.ObjectId, set bson.M) (err error) {
+	session, err := m.s.copySession(context.Background())
+	if err != nil {
+		return
+	}
+	defer m.s.releaseSession(session)
+
+	db, coll := m.s.dbFor(session, m.s.CollResults)
+	_, err = session.DB(db).C(coll).UpsertId(id, bson.M{
+		"$set": set,
+	})
+	return
+}
+
+func (m *mgoStore) Meta(id bson.ObjectId, result interface{}) (err error) {
+	session, err := m.s.copySession(context.Background())
+	if err != nil {
+		return
+	}
+	defer m.s.releaseSession(session)
+
+	db, coll := m.s.dbFor(session, m.s.CollResults)
+	return session.DB(db).C(coll).FindId(id).One(result)
+}
+
+func (m *mgoStore) Searches(filter bson.M, skip int, result interface{}) (err error) {
+	session, err := m.s.copySession(context.Background())
+	if err != nil {
+		return
+	}
+	defer m.s.releaseSession(session)
+
+	db, coll := m.s.dbFor(session, m.s.CollResults)
+	return session.DB(db).C(coll).Find(filter).Sort("-_id").Skip(skip).All(result)
+}
+
+func (m *mgoStore) Results(id bson.ObjectId, opts ResultOptions) (it Iter, err error) {
+	session, err := m.s.copySession(context.Background())
+	if err != nil {
+		return
+	}
+
+	db, coll := m.s.resultsFor(session, id)
+	query := session.DB(db).C(coll).Find(nil).Select(bson.M{"_id": 1})
+	switch {
+	case opts.Sort > 0:
+		query = query.Sort("value.pubdate")
+	case opts.Sort < 0:
+		query = query.Sort("-value.pubdate")
+	}
+	if opts.Skip > 0 {
+		query = query.Skip(opts.Skip)
+	}
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit)
+	}
+	return &mgoIter{
+		Iter:    query.Iter(),
+		m:       m,
+		session: session,
+		id:      id,
+	}, nil
+}
+
+func (m *mgoStore) ResultCount(id bson.ObjectId) (n int, err error) {
+	session, err := m.s.copySession(context.Background())
+	if err != nil {
+		return
+	}
+	defer m.s.releaseSession(session)
+	return m.resultCount(session, id)
+}
+
+func (m *mgoStore) resultCount(session *mgo.Session, id bson.ObjectId) (n int, err error) {
+	db, coll := m.s.resultsFor(session, id)
+	return session.DB(db).C(coll).Count()
+}
+
+func (m *mgoStore) Items(ids []interface{}) (items []bson.Raw, err error) {
+	session, err := m.s.copySession(context.Background())
+	if err != nil {
+		return
+	}
+	defer m.s.releaseSession(session)
+	return m.items(session, ids)
+}
+
+func (m *mgoStore) items(session *mgo.Session, ids []interface{}) (items []bson.Raw, err error) {
+	db, coll := m.s.dbFor(session, m.s.CollItems)
+	err = session.DB(db).C(coll).Find(bson.M{"_id": bson.M{"$in": ids}}).All(&items)
+	return
+}
+
+func (m *mgoStore) Drop(id bson.ObjectId) (err error) {
+	session, err := m.s.copySession(context.Background())
+	if err != nil {
+		return
+	}
+	defer m.s.releaseSession(session)
+
+	db, coll := m.s.resultsFor(session, id)
+	if err = session.DB(db).C(coll).DropCollection(); err != nil {
+		// Failed and cancelled searches may never have written results
+		if qerr, ok := err.(*mgo.QueryError); ok && qerr.Message == "ns not found" {
+			err = nil
+		}
+	}
+	return
+}
+
+// Close releases the shared session
+func (m *mgoStore) Close() {
+	m.s.shared.Lock()
+	defer m.s.shared.Unlock()
+	if m.s.shared.session != nil {
+		m.s.shared.session.Close()
+		m.s.shared.session = nil
+	}
+}
+
+func (m *mgoStore) doMapReduce(session *mgo.Session, job *Job) (info *mgo.MapReduceInfo, err error) {
+	// logger.Trace.Printf("doMapReduce: mgoQuery: %+v", job.Query)
+	// logger.Trace.Printf("doMapReduce: scope: %+v", job.Scope)
+
+	db, coll := m.s.resultsFor(session, job.Id)
+
+	mr := &mgo.MapReduce{
+		Reduce: `function(key, values) { return values[0] }`,
+		Out: bson.M{
+			"replace": coll,
+			"db":      db,
+		},
+		Scope: bson.M{
+			"query":         job.Scope,
+			"caseSensitive": job.CaseSensitive,
+			"normalize":     job.Normalization.script(),
+		},
+		Verbose: true,
+	}
+
+	if job.MapReduce {
+		mr.Map = fmt.Sprintf(mapFunc, job.All, job.Pubdate)
+	} else {
+		mr.Map = fmt.Sprintf(mapFuncImmediate, job.Pubdate)
+	}
+
+	db, coll = m.s.dbFor(session, m.s.CollItems)
+	return session.DB(db).C(coll).Find(job.Query).MapReduce(mr, nil)
+}
+
+// sideSession copies a session from the pool for work alongside a running
+// search, whose own session is still tied up waiting on the server. Waits at
+// most one poll interval for a free slot.
+func (m *mgoStore) sideSession() (session *mgo.Session, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.s.pollInterval)
+	defer cancel()
+	return m.s.copySession(ctx)
+}
+
+// currentOps lists the server operations writing into the results collection
+// for search id
+func (m *mgoStore) currentOps(session *mgo.Session, id bson.ObjectId) (ops []bson.M, err error) {
+	_, out := m.s.resultsFor(session, id)
+
+	var current struct {
+		Inprog []bson.M `bson:"inprog"`
+	}
+	if err = session.DB("admin").Run(bson.D{
+		{Name: "currentOp", Value: 1},
+		{Name: "$or", Value: []bson.M{
+			{"command.out.replace": out},
+			{"query.out.replace": out},
+			{"command.comment": out},
+		}},
+	}, &current); err != nil {
+		return
+	}
+	return current.Inprog, nil
+}
+
+// killOp asks the server to terminate any operation writing into the results
+// collection for search id. killed reports whether one was found.
+func (m *mgoStore) killOp(id bson.ObjectId) (killed bool, err error) {
+	session, err := m.sideSession()
+	if err != nil {
+		return
+	}
+	defer m.s.releaseSession(session)
+
+	ops, err := m.currentOps(session, id)
+	if err != nil {
+		return
+	}
+
+	for _, op := range ops {
+		// logger.Trace.Printf("killOp: killing %v", op["opid"])
+		if err = session.DB("admin").Run(bson.D{
+			{Name: "killOp", Value: 1},
+			{Name: "op", Value: op["opid"]},
+		}, nil); err != nil {
+			return
+		}
+		killed = true
+	}
+	return
+}
+
+// recordProgress copies the server's progress report for the running search
+// id into the metadata document
+func (m *mgoStore) recordProgress(id bson.ObjectId) (err error) {
+	session, err := m.sideSession()
+	if err != nil {
+		return
+	}
+	defer m.s.releaseSession(session)
+
+	ops, err := m.currentOps(session, id)
+	if err != nil || len(ops) == 0 {
+		return
+	}
+
+	progress, ok := ops[0]["progress"]
+	if !ok {
+		return
+	}
+
+	db, coll := m.s.dbFor(session, m.s.CollResults)
+	return session.DB(db).C(coll).UpdateId(id, bson.M{
+		"$set": bson.M{
+			"progress": progress,
+		},
+	})
+}
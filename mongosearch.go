@@ -1,6 +1,7 @@
 package mongosearch
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/300brand/logger"
@@ -8,25 +9,92 @@ import (
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"strings"
+	"sync"
 	"time"
 )
 
 type MongoSearch struct {
-	CollItems     string                    // Collection of items to search
-	CollResults   string                    // Search resutls collection
-	Conversions   map[string]ConversionFunc // Field -> ConversionFunc map; if field not found, entire string used
-	Rewrites      map[string]string         // Rewrite rules for final query output (allows simpler inbound queries and rewrite of default "" field)
-	Url           string                    // Connection string to database: host:port/db
+	CollItems     string               // Collection of items to search
+	CollResults   string               // Search resutls collection
+	Conversions   map[string]Converter // Field -> Converter map; if field not found, entire string used
+	Rewrites      map[string]string    // Rewrite rules for final query output (allows simpler inbound queries and rewrite of default "" field)
+	Url           string               // Connection string to database: host:port/db
 	caseSensitive bool
 	reqMapReduce  bool
+	shared        *shared // State common to every search run through this instance
+	store         Store
+	readPref      ReadPreference
+	socketTimeout time.Duration
+	pollInterval  time.Duration
+	retention     Retention
+	backend       Backend
+	noJavaScript  bool
+	window        DateWindow
+	clock         func() time.Time
+	location      *time.Location
+	layouts       []string
+	wildcards     Wildcards
+	normalization Normalization
+	analyzer      Analyzer
+	synonyms      [][]string
+	expansions    []Expansion          // Synonyms used by the current search
+	dates         map[string]time.Time // Resolved date bounds of the current search, by dateBound value
 	fields        struct {
 		all     string
 		keyword string
 		pubdate string
 		pubid   string
+		shingle string
 	}
 }
 
+// shared holds the connection and worker state used by all copies of a
+// MongoSearch
+type shared struct {
+	sync.Mutex
+	session *mgo.Session       // Dialed on first use; copied for each search
+	sockets chan struct{}      // Limits concurrent copies of session
+	workers chan struct{}      // Limits concurrently running async searches
+	ctx     context.Context    // Parent of async searches and the janitor
+	stop    context.CancelFunc // Cancels ctx
+}
+
+// ReadPreference determines which replica set members searches read from
+type ReadPreference int
+
+const (
+	ReadStrong    ReadPreference = iota // Always read from the primary
+	ReadMonotonic                       // Read from secondaries until the first write
+	ReadEventual                        // Read from any member
+)
+
+// Backend selects how searches are executed on the server
+type Backend int
+
+const (
+	BackendMapReduce Backend = iota // Always use map-reduce
+	BackendAggregate                // Use an aggregation pipeline unless the query needs map-reduce
+)
+
+func (b Backend) String() string {
+	if b == BackendAggregate {
+		return "aggregate"
+	}
+	return "mapreduce"
+}
+
+// Values stored in the status field of the search's metadata document
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
+// TimeLayout is the first of the date layouts used when none are set with
+// SetDateLayouts
 var TimeLayout = "2006-01-02"
 
 // serverUrl - Yup.
@@ -35,24 +103,95 @@ var TimeLayout = "2006-01-02"
 //             connection string is uesd)
 func New(serverUrl, cItems, cResults string) (s *MongoSearch, err error) {
 	s = &MongoSearch{
-		CollItems:   cItems,
-		CollResults: cResults,
-		Url:         serverUrl,
+		CollItems:     cItems,
+		CollResults:   cResults,
+		Url:           serverUrl,
+		shared:        new(shared),
+		socketTimeout: 60 * time.Minute,
+		pollInterval:  time.Second,
 	}
-	s.Conversions = make(map[string]ConversionFunc)
+	s.Conversions = make(map[string]Converter)
 	s.Rewrites = make(map[string]string)
+	s.store = &mgoStore{s: s}
+	s.shared.ctx, s.shared.stop = context.WithCancel(context.Background())
+	s.SetWorkers(4)
 	return
 }
 
+// NewWithSession uses an existing session instead of dialing Url. The session
+// is copied, so the caller remains responsible for closing the original.
+func NewWithSession(session *mgo.Session, cItems, cResults string) (s *MongoSearch, err error) {
+	if s, err = New("", cItems, cResults); err != nil {
+		return
+	}
+	s.shared.session = session.Copy()
+	return
+}
+
+// Close cancels any async searches, stops the janitor and closes the store.
+// With the default store, searches started afterwards will dial a new
+// session.
+func (s *MongoSearch) Close() {
+	s.shared.Lock()
+	s.shared.stop()
+	s.shared.ctx, s.shared.stop = context.WithCancel(context.Background())
+	s.shared.Unlock()
+	s.store.Close()
+}
+
 func (s *MongoSearch) SetAll(name string) {
 	s.fields.all = name
 }
 
+func (s *MongoSearch) SetBackend(backend Backend) {
+	s.backend = backend
+}
+
 func (s *MongoSearch) SetCaseSensitive(sensitive bool) {
 	s.caseSensitive = sensitive
 }
 
-func (s *MongoSearch) SetKeyword(name string, convertFunc ConversionFunc, aliases ...string) {
+// SetPoolLimit caps the number of sockets searches may hold at once; further
+// searches wait for one to free up. A limit of zero or less removes the cap.
+// Must be called before the first search.
+func (s *MongoSearch) SetPoolLimit(limit int) {
+	if limit <= 0 {
+		s.shared.sockets = nil
+		return
+	}
+	s.shared.sockets = make(chan struct{}, limit)
+}
+
+// SetPollInterval sets how often progress is recorded for running searches
+// and how often Wait checks on a search. Defaults to one second.
+func (s *MongoSearch) SetPollInterval(d time.Duration) {
+	s.pollInterval = d
+}
+
+func (s *MongoSearch) SetReadPreference(pref ReadPreference) {
+	s.readPref = pref
+}
+
+// SetSocketTimeout sets how long a search may wait on the server before
+// failing. Defaults to 60 minutes.
+func (s *MongoSearch) SetSocketTimeout(d time.Duration) {
+	s.socketTimeout = d
+}
+
+// SetJavaScript controls whether searches may run JavaScript on the server.
+// With it disabled, queries which can only be answered by the map function
+// return an error.
+func (s *MongoSearch) SetJavaScript(enabled bool) {
+	s.noJavaScript = !enabled
+}
+
+// SetShingles names the field holding each item's word pairs, as produced by
+// Shingles, allowing phrases to be matched without the map function
+func (s *MongoSearch) SetShingles(name string) {
+	s.fields.shingle = name
+}
+
+func (s *MongoSearch) SetKeyword(name string, convertFunc Converter, aliases ...string) {
 	for _, alias := range aliases {
 		s.Rewrite(alias, name)
 	}
@@ -61,7 +200,7 @@ func (s *MongoSearch) SetKeyword(name string, convertFunc ConversionFunc, aliase
 	s.fields.keyword = name
 }
 
-func (s *MongoSearch) SetPubdate(name string, convertFunc ConversionFunc, aliases ...string) {
+func (s *MongoSearch) SetPubdate(name string, convertFunc Converter, aliases ...string) {
 	for _, alias := range aliases {
 		s.Rewrite(alias, name)
 	}
@@ -70,7 +209,7 @@ func (s *MongoSearch) SetPubdate(name string, convertFunc ConversionFunc, aliase
 	s.fields.pubdate = name
 }
 
-func (s *MongoSearch) SetPubid(name string, convertFunc ConversionFunc, aliases ...string) {
+func (s *MongoSearch) SetPubid(name string, convertFunc Converter, aliases ...string) {
 	for _, alias := range aliases {
 		s.Rewrite(alias, name)
 	}
@@ -79,7 +218,7 @@ func (s *MongoSearch) SetPubid(name string, convertFunc ConversionFunc, aliases
 	s.fields.pubid = name
 }
 
-func (s *MongoSearch) Convert(field string, convertFunc ConversionFunc) {
+func (s *MongoSearch) Convert(field string, convertFunc Converter) {
 	s.Conversions[field] = convertFunc
 }
 
@@ -88,13 +227,26 @@ func (s *MongoSearch) Rewrite(field, newName string) {
 }
 
 func (s *MongoSearch) Search(query string) (id bson.ObjectId, err error) {
+	return s.SearchContext(context.Background(), query)
+}
+
+func (s *MongoSearch) SearchInto(query string, id bson.ObjectId) (err error) {
+	return s.SearchIntoContext(context.Background(), query, id)
+}
+
+// SearchContext works like Search, but gives up once ctx is done. When the
+// context is cancelled or its deadline passes, the map-reduce running on the
+// server is killed, the metadata document is marked as cancelled and ctx's
+// error is returned.
+func (s *MongoSearch) SearchContext(ctx context.Context, query string) (id bson.ObjectId, err error) {
 	id = bson.NewObjectId()
-	err = s.doSearch(query, id)
+	err = s.copy().doSearch(ctx, query, id)
 	return
 }
 
-func (s *MongoSearch) SearchInto(query string, id bson.ObjectId) (err error) {
-	return s.doSearch(query, id)
+// SearchIntoContext is the context-aware version of SearchInto
+func (s *MongoSearch) SearchIntoContext(ctx context.Context, query string, id bson.ObjectId) (err error) {
+	return s.copy().doSearch(ctx, query, id)
 }
 
 func (s *MongoSearch) dbFor(session *mgo.Session, collection string) (db, coll string) {
@@ -105,6 +257,69 @@ func (s *MongoSearch) dbFor(session *mgo.Session, collection string) (db, coll s
 	return bits[0], bits[1]
 }
 
+// copySession hands out a copy of the shared session, dialing Url the first
+// time through. Blocks while the pool limit is reached. Copies must be given
+// back with releaseSession.
+func (s *MongoSearch) copySession(ctx context.Context) (session *mgo.Session, err error) {
+	if s.shared.sockets != nil {
+		select {
+		case s.shared.sockets <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s.shared.Lock()
+	defer s.shared.Unlock()
+
+	if s.shared.session == nil {
+		if s.shared.session, err = mgo.Dial(s.Url); err != nil {
+			s.shared.session = nil
+			if s.shared.sockets != nil {
+				<-s.shared.sockets
+			}
+			return
+		}
+	}
+
+	session = s.shared.session.Copy()
+	session.SetSocketTimeout(s.socketTimeout)
+	switch s.readPref {
+	case ReadStrong:
+		session.SetMode(mgo.Strong, true)
+	case ReadMonotonic:
+		session.SetMode(mgo.Monotonic, true)
+	case ReadEventual:
+		session.SetMode(mgo.Eventual, true)
+	}
+	return
+}
+
+func (s *MongoSearch) releaseSession(session *mgo.Session) {
+	session.Close()
+	if s.shared.sockets != nil {
+		<-s.shared.sockets
+	}
+}
+
+// copy returns a MongoSearch sharing configuration and connections with s
+// but with its own per-search state, so searches may run concurrently
+func (s *MongoSearch) copy() *MongoSearch {
+	c := *s
+	c.reqMapReduce = false
+	c.expansions = nil
+	c.dates = nil
+	return &c
+}
+
+// resultsFor returns the location of the collection holding the results for
+// search id
+func (s *MongoSearch) resultsFor(session *mgo.Session, id bson.ObjectId) (db, coll string) {
+	db, coll = s.dbFor(session, s.CollResults)
+	coll = fmt.Sprintf("%s_%s", coll, id.Hex())
+	return
+}
+
 func (s *MongoSearch) buildScope(query *searchquery.Query) (scope bson.M, err error) {
 	// logger.Trace.Printf("buildScope: R:%d O:%d E:%d", len(query.Required), len(query.Optional), len(query.Excluded))
 	scope = bson.M{}
@@ -132,7 +347,24 @@ func (s *MongoSearch) buildScope(query *searchquery.Query) (scope bson.M, err er
 	if err = loop(query.Optional, "or"); err != nil {
 		return
 	}
-	if err = loop(query.Excluded, "nor"); err != nil {
+	// Exclusions on other fields are enforced by the query alone; leaving out
+	// just their non-keyword terms here would exclude too much
+	excluded := make([]searchquery.SubQuery, 0, len(query.Excluded))
+	for i := range query.Excluded {
+		sq := &query.Excluded[i]
+		if !s.isForeign(sq) {
+			excluded = append(excluded, *sq)
+			continue
+		}
+		exact, err := s.isExact(sq)
+		if err != nil {
+			return nil, err
+		}
+		if !exact {
+			return nil, fmt.Errorf("Cannot exclude %s: phrases may not be combined with fields other than %s", sq, s.fields.keyword)
+		}
+	}
+	if err = loop(excluded, "nor"); err != nil {
 		return
 	}
 	return
@@ -149,50 +381,43 @@ func (s *MongoSearch) buildSubscope(subquery *searchquery.SubQuery) (subscope in
 		return
 	}
 
-	return subquery.Value, nil
+	return s.analyzeTerms(subquery.Value)
 }
 
-func (s *MongoSearch) doMapReduce(session *mgo.Session, query *searchquery.Query, id bson.ObjectId) (info *mgo.MapReduceInfo, err error) {
-	// logger.Trace.Printf("doMapReduce: starting")
-	mgoQuery, err := s.buildQuery(query)
-	if err != nil {
-		return
-	}
-	// logger.Trace.Printf("doMapReduce: mgoQuery: %+v", mgoQuery)
-	scope, err := s.buildScope(query)
-	if err != nil {
+// recordCancel marks search id as cancelled in its metadata document. cause is
+// returned unless recording fails.
+func (s *MongoSearch) recordCancel(id bson.ObjectId, cause error) (err error) {
+	if err = s.store.SetMeta(id, bson.M{
+		"status":      StatusCancelled,
+		"cancelledAt": time.Now(),
+		"error":       cause.Error(),
+	}); err != nil {
 		return
 	}
-	// logger.Trace.Printf("doMapReduce: scope: %+v", scope)
-
-	db, coll := s.dbFor(session, s.CollResults)
-	coll = fmt.Sprintf("%s_%s", coll, id.Hex())
+	return cause
+}
 
-	job := &mgo.MapReduce{
-		Reduce: `function(key, values) { return values[0] }`,
-		Out: bson.M{
-			"replace": coll,
-			"db":      db,
-		},
-		Scope: bson.M{
-			"query":         scope,
-			"caseSensitive": s.caseSensitive,
-		},
-		Verbose: true,
+// recordFailure marks search id as failed in its metadata document along with
+// whatever was built of the query so far
+func (s *MongoSearch) recordFailure(id bson.ObjectId, cause error, built, scope bson.M) (err error) {
+	set := bson.M{
+		"status":   StatusFailed,
+		"error":    cause.Error(),
+		"failedAt": time.Now(),
 	}
-
-	if s.reqMapReduce {
-		job.Map = fmt.Sprintf(mapFunc, s.fields.all)
-	} else {
-		job.Map = mapFuncImmediate
+	// Stored as JSON; Mongo will not accept the $-prefixed operator keys
+	if built != nil {
+		jsonBuilt, _ := json.Marshal(built)
+		set["query.built"] = string(jsonBuilt)
 	}
-
-	db, coll = s.dbFor(session, s.CollItems)
-	return session.DB(db).C(coll).Find(mgoQuery).MapReduce(job, nil)
+	if scope != nil {
+		set["scope"] = scope
+	}
+	return s.store.SetMeta(id, set)
 }
 
-func (s *MongoSearch) doSearch(query string, id bson.ObjectId) (err error) {
-	// Check if all the fields are defined
+// checkFields ensures all the fields needed to run a search are defined
+func (s *MongoSearch) checkFields() error {
 	switch "" {
 	case s.fields.all:
 		return fmt.Errorf("Use SetAll() to define a value for the all-words array")
@@ -203,57 +428,96 @@ func (s *MongoSearch) doSearch(query string, id bson.ObjectId) (err error) {
 	case s.fields.pubid:
 		return fmt.Errorf("Use SetPubid() to define a value for the all-words array")
 	}
+	return nil
+}
 
-	session, err := mgo.Dial(s.Url)
-	if err != nil {
+func (s *MongoSearch) doSearch(ctx context.Context, query string, id bson.ObjectId) (err error) {
+	if err = s.checkFields(); err != nil {
 		return
 	}
-	defer session.Close()
 
-	session.SetSocketTimeout(60 * time.Minute)
+	if err = ctx.Err(); err != nil {
+		return
+	}
 
-	db, coll := s.dbFor(session, s.CollResults)
+	var built, scope bson.M
+	defer func() {
+		switch {
+		case err == nil:
+		case err == ctx.Err():
+			err = s.recordCancel(id, err)
+		default:
+			if err := s.recordFailure(id, err, built, scope); err != nil {
+				logger.Error.Printf("Recording failure of %s: %s", id.Hex(), err)
+			}
+		}
+	}()
 
-	q, err := searchquery.ParseGreedy(query)
+	q, err := parseQuery(query)
 	if err != nil {
 		return
 	}
+	// Recorded before building, which rewrites parts of q such as relative dates
+	parsed := q.String()
 
 	// logger.Debug.Printf("Query: %+v", q)
-	built, err := s.buildQuery(q)
-	if err != nil {
+	if built, err = s.buildQuery(q); err != nil {
+		return
+	}
+	if s.reqMapReduce && s.noJavaScript {
+		err = fmt.Errorf("Query requires server-side JavaScript, which is disabled")
 		return
 	}
 	jsonBuilt, _ := json.Marshal(built)
 	logger.Info.Printf("Parsed: %s", jsonBuilt)
 
-	if _, err = session.DB(db).C(coll).UpsertId(id, bson.M{
-		"$set": bson.M{
-			"query": bson.M{
-				"original": query,
-				"parsed":   q.String(),
-			},
-			"doMapReduce":   s.reqMapReduce,
-			"start":         time.Now(),
-			"caseSensitive": s.caseSensitive,
-		},
-	}); err != nil {
+	if scope, err = s.buildScope(q); err != nil {
 		return
 	}
 
-	info, err := s.doMapReduce(session, q, id)
-	if err != nil {
-		return
+	// Phrases and exclusions can only be checked by the map function, while
+	// without JavaScript only the pipeline can run
+	backend := s.backend
+	switch {
+	case s.reqMapReduce:
+		backend = BackendMapReduce
+	case s.noJavaScript:
+		backend = BackendAggregate
 	}
 
-	if err = session.DB(db).C(coll).UpdateId(id, bson.M{
-		"$set": bson.M{
-			"end":  time.Now(),
-			"info": info,
+	if err = s.store.SetMeta(id, bson.M{
+		"query": bson.M{
+			"original": query,
+			"parsed":   parsed,
 		},
+		"synonyms":      s.expansions,
+		"doMapReduce":   s.reqMapReduce,
+		"backend":       backend.String(),
+		"status":        StatusRunning,
+		"start":         time.Now(),
+		"caseSensitive": s.caseSensitive,
 	}); err != nil {
 		return
 	}
 
-	return
+	info, err := s.store.Execute(ctx, &Job{
+		Id:            id,
+		Query:         built,
+		Scope:         scope,
+		MapReduce:     s.reqMapReduce,
+		Backend:       backend,
+		CaseSensitive: s.caseSensitive,
+		Normalization: s.normalization,
+		All:           s.fields.all,
+		Pubdate:       s.fields.pubdate,
+	})
+	if err != nil {
+		return
+	}
+
+	return s.store.SetMeta(id, bson.M{
+		"status": StatusDone,
+		"end":    time.Now(),
+		"info":   info,
+	})
 }
@@ -1,6 +1,8 @@
 package mongosearch
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
@@ -36,6 +38,106 @@ func TestQuery(t *testing.T) {
 	t.Logf("id: %s", id)
 }
 
+func TestSearchContextCancelled(t *testing.T) {
+	s, err := New(*ServerAddr, "Items", "Results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.SearchContext(ctx, "a OR b"); err != context.Canceled {
+		t.Fatalf("Expected %s, got %v", context.Canceled, err)
+	}
+}
+
+func TestCancelGivesUp(t *testing.T) {
+	s, err := New("", "Items", "Results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetPollInterval(time.Millisecond)
+
+	tests := []struct {
+		Err   error
+		Calls int
+	}{
+		{errors.New("no such operation"), killAttempts},
+		{&mgo.QueryError{Code: 13, Message: "not authorized on admin to execute command"}, 1},
+	}
+	for _, test := range tests {
+		calls := 0
+		m := &mgoStore{s: s, kill: func(bson.ObjectId) (bool, error) {
+			calls++
+			return false, test.Err
+		}}
+
+		// The operation never finishes, so only giving up returns
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		<-ctx.Done()
+		deadline, _ := ctx.Deadline()
+		m.cancel(bson.NewObjectId(), make(chan mapReduceResult))
+		cancel()
+
+		if late := time.Since(deadline); late > 500*time.Millisecond {
+			t.Errorf("%s: returned %s after the deadline", test.Err, late)
+		}
+		if calls != test.Calls {
+			t.Errorf("%s: expected %d attempts, got %d", test.Err, test.Calls, calls)
+		}
+	}
+}
+
+func TestSearchFailureRecorded(t *testing.T) {
+	if *ServerAddr == "" {
+		t.Skip("No mongo server provided")
+	}
+
+	s, err := New(*ServerAddr, "Items", "Results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+
+	id, err := s.Search("date:not-a-date AND a")
+	if err == nil {
+		t.Fatal("Expected an error converting the date")
+	}
+
+	status, err := s.Status(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != StatusFailed || status.Error == "" {
+		t.Errorf("Expected failure to be recorded, got %+v", status)
+	}
+}
+
+func TestPoolLimit(t *testing.T) {
+	s, err := New(*ServerAddr, "Items", "Results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetPoolLimit(1)
+
+	// Hold the only slot so the next copy has to wait
+	s.shared.sockets <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.copySession(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %s, got %v", context.DeadlineExceeded, err)
+	}
+}
+
 func resetDB(t *testing.T) {
 	sess, err := mgo.Dial(*ServerAddr)
 	if err != nil {
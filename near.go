@@ -0,0 +1,91 @@
+package mongosearch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nearExpr splits a phrase on a NEAR/n or ONEAR/n operator. Both match words
+// within n words of each other; ONEAR also requires them in the order given.
+var nearExpr = regexp.MustCompile(`(?i)^(.+?) (o?near)/(\d+) (.+)$`)
+
+// nearToken matches the operator itself
+var nearToken = regexp.MustCompile(`(?i)^o?near/\d+$`)
+
+// near is a proximity search parsed from a phrase
+type near struct {
+	left, right []string
+	n           int
+	ordered     bool
+}
+
+// parseNear parses a phrase holding a proximity operator. ok is false for
+// ordinary phrases.
+func parseNear(phrase string) (nr near, ok bool, err error) {
+	m := nearExpr.FindStringSubmatch(phrase)
+	if m == nil {
+		return
+	}
+	if nearExpr.MatchString(m[4]) {
+		err = fmt.Errorf("Only one NEAR operator is allowed in %q", phrase)
+		return
+	}
+	nr.n, err = strconv.Atoi(m[3])
+	if err != nil {
+		err = fmt.Errorf("Invalid distance in %q: %s", phrase, err)
+		return
+	}
+	nr.left, nr.right = strings.Fields(m[1]), strings.Fields(m[4])
+	nr.ordered = strings.ToLower(m[2]) == "onear"
+	return nr, true, nil
+}
+
+// isNear reports whether value holds a proximity operator
+func isNear(value string) bool {
+	return nearExpr.MatchString(value)
+}
+
+// nearWords drops the proximity operator from the converted words of a
+// phrase, leaving the words every match must contain
+func nearWords(out Converted) Converted {
+	words, ok := out.Value.([]string)
+	if !ok {
+		return out
+	}
+	kept := make([]string, 0, len(words))
+	for _, word := range words {
+		if !nearToken.MatchString(word) {
+			kept = append(kept, word)
+		}
+	}
+	out.Value = kept
+	return out
+}
+
+// hasNear mirrors hasNear in mapFunc: left and right must each appear with at
+// most n words between them
+func hasNear(left, right []term, n int, ordered bool, all []string) bool {
+	for i := range all {
+		if !phraseAt(left, all, i) {
+			continue
+		}
+		from, to := i-n-len(right), i+len(left)+n
+		if from < 0 {
+			from = 0
+		}
+		for j := from; j <= to && j < len(all); j++ {
+			if !phraseAt(right, all, j) {
+				continue
+			}
+			if j >= i+len(left) {
+				return true
+			}
+			if !ordered && i >= j+len(right) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseNear(t *testing.T) {
+	tests := []struct {
+		Phrase      string
+		Left, Right string
+		N           int
+		Ordered     bool
+	}{
+		{"Google NEAR/5 data center", "Google", "data center", 5, false},
+		{"big data ONEAR/0 cloud", "big data", "cloud", 0, true},
+		{"a near/12 b", "a", "b", 12, false},
+	}
+	for _, test := range tests {
+		nr, ok, err := parseNear(test.Phrase)
+		if err != nil || !ok {
+			t.Errorf("%s: not parsed (%v)", test.Phrase, err)
+			continue
+		}
+		if l, r := strings.Join(nr.left, " "), strings.Join(nr.right, " "); l != test.Left || r != test.Right || nr.n != test.N || nr.ordered != test.Ordered {
+			t.Errorf("%s: got %q %q %d %v", test.Phrase, l, r, nr.n, nr.ordered)
+		}
+	}
+
+	for _, phrase := range []string{"data center", "NEAR/5 b", "a NEAR/x b", "a NEAR/5"} {
+		if _, ok, _ := parseNear(phrase); ok {
+			t.Errorf("%s: should not be a proximity search", phrase)
+		}
+	}
+	if _, _, err := parseNear("a NEAR/1 b NEAR/2 c"); err == nil {
+		t.Error("Expected an error for two operators")
+	}
+}
+
+func TestEvalScopeNear(t *testing.T) {
+	//       0      1     2       3     4       5     6     7
+	text := "Google opens a new data center in Iowa"
+	all := strings.Fields(text)
+	tests := []struct {
+		Phrase string
+		Match  bool
+	}{
+		// Words between: opens a new
+		{"Google NEAR/3 data center", true},
+		{"Google NEAR/2 data center", false},
+		{"data center NEAR/3 Google", true},
+		{"Google ONEAR/3 data center", true},
+		{"data center ONEAR/3 Google", false},
+		// Adjacent at the very start and end
+		{"Google NEAR/0 opens", true},
+		{"opens NEAR/0 Google", true},
+		{"opens ONEAR/0 Google", false},
+		{"in ONEAR/0 Iowa", true},
+		{"Iowa NEAR/0 in", true},
+		{"Google NEAR/6 Iowa", true},
+		{"Google NEAR/5 Iowa", false},
+		// Overlapping words are not near each other
+		{"data center NEAR/5 center", false},
+		{"Google NEAR/5 missing", false},
+		{"goo* NEAR/3 /dat[aum]/", true},
+	}
+	for _, test := range tests {
+		scope := map[string]interface{}{"and": []interface{}{test.Phrase}}
+		match, err := EvalScope(scope, all, false)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Phrase, err)
+		}
+		if match != test.Match {
+			t.Errorf("%s: expected %v, got %v", test.Phrase, test.Match, match)
+		}
+	}
+}
+
+func TestBuildQueryNear(t *testing.T) {
+	ms, _ := New("", "Items", "Results")
+	ms.SetKeyword("keywords", ConvertSpaces)
+	ms.SetPubdate("pubdate", ConvertDateInt, "published")
+	ms.SetShingles("shingles")
+
+	query, err := parseQuery(`published:2014-06-01 AND keywords:"Google NEAR/5 data"`)
+	if err != nil {
+		t.Fatalf("parseQuery: %s", err)
+	}
+	mgoQuery, err := ms.buildQuery(query)
+	if err != nil {
+		t.Fatalf("buildQuery: %s", err)
+	}
+	expect := `{"$or":[{"keywords":{"$all":["Google","data"]},"pubdate":20140601}]}`
+	if b, _ := json.Marshal(mgoQuery); string(b) != expect {
+		t.Errorf("Expect: %s", expect)
+		t.Errorf("Got:    %s", b)
+	}
+	if !ms.reqMapReduce {
+		t.Error("Expected proximity search to require map-reduce")
+	}
+
+	scope, err := ms.buildScope(query)
+	if err != nil {
+		t.Fatalf("buildScope: %s", err)
+	}
+	expect = `{"and":["Google NEAR/5 data"]}`
+	if b, _ := json.Marshal(scope); string(b) != expect {
+		t.Errorf("Expect: %s", expect)
+		t.Errorf("Got:    %s", b)
+	}
+}
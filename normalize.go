@@ -0,0 +1,159 @@
+package mongosearch
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Normalization selects how words are made comparable before phrases are
+// matched. Flags may be combined.
+type Normalization int
+
+const (
+	NormalizePunctuation Normalization = 1 << iota // Strip punctuation, splitting words joined by it
+	NormalizeFold                                  // Fold compatibility forms, such as ligatures and full-width letters
+	NormalizeDiacritics                            // Remove accents and other diacritics
+)
+
+const (
+	NormalizeNone Normalization = 0
+	NormalizeAll                = NormalizePunctuation | NormalizeFold | NormalizeDiacritics
+)
+
+// SetNormalization sets how query terms and the all-words array are normalized.
+// The keyword field, and the shingles if used, must be stored normalized the
+// same way; see Normalization.Words.
+func (s *MongoSearch) SetNormalization(n Normalization) {
+	s.normalization = n
+}
+
+// normalizeRanges are the blocks normalized: Latin letters and punctuation,
+// combining diacritics, general punctuation, ligatures and full-width forms.
+// Characters outside them are left alone, in Go and in mapFunc alike.
+var normalizeRanges = [][2]rune{
+	{0x0021, 0x007e},
+	{0x00a0, 0x024f},
+	{0x0300, 0x036f},
+	{0x1e00, 0x1eff},
+	{0x2000, 0x206f},
+	{0xfb00, 0xfb06},
+	{0xff01, 0xff5e},
+}
+
+// strokeLetters have marks which do not decompose
+var strokeLetters = map[rune]string{
+	'Đ': "D", 'đ': "d",
+	'Ħ': "H", 'ħ': "h",
+	'ı': "i",
+	'Ł': "L", 'ł': "l",
+	'Ø': "O", 'ø': "o",
+}
+
+// normalizeTables holds the replacement for each character changed by every
+// combination of flags
+var normalizeTables [NormalizeAll + 1]map[rune]string
+
+func init() {
+	for n := range normalizeTables {
+		normalizeTables[n] = Normalization(n).buildTable()
+	}
+}
+
+func (n Normalization) buildTable() (table map[rune]string) {
+	table = make(map[rune]string)
+	if n == NormalizeNone {
+		return
+	}
+	for _, r := range normalizeRanges {
+		for c := r[0]; c <= r[1]; c++ {
+			if out := n.normalizeRune(c); out != string(c) {
+				table[c] = out
+			}
+		}
+	}
+	return
+}
+
+func (n Normalization) normalizeRune(c rune) (out string) {
+	out = string(c)
+	if n&NormalizeFold != 0 {
+		out = norm.NFKC.String(out)
+	}
+	if n&NormalizeDiacritics != 0 {
+		out = strings.Map(func(r rune) rune {
+			if unicode.Is(unicode.Mn, r) {
+				return -1
+			}
+			return r
+		}, norm.NFD.String(out))
+		if s, ok := strokeLetters[c]; ok {
+			out = s
+		}
+	}
+	if n&NormalizePunctuation != 0 {
+		out = strings.Map(func(r rune) rune {
+			switch {
+			case r == '\'' || r == '’':
+				return -1
+			case unicode.IsPunct(r):
+				return ' '
+			}
+			return r
+		}, out)
+	}
+	return
+}
+
+// Words normalizes text and splits it into words. Use it to build the
+// all-words array and keyword field of items, so they are normalized the same
+// way as queries.
+func (n Normalization) Words(text string) []string {
+	return n.words(text, "")
+}
+
+// words normalizes text, leaving the characters in keep as they are
+func (n Normalization) words(text, keep string) []string {
+	table := normalizeTables[n&NormalizeAll]
+	if len(table) == 0 {
+		return strings.Fields(text)
+	}
+	var buf strings.Builder
+	for _, c := range text {
+		if out, ok := table[c]; ok && !strings.ContainsRune(keep, c) {
+			buf.WriteString(out)
+		} else {
+			buf.WriteRune(c)
+		}
+	}
+	return strings.Fields(buf.String())
+}
+
+// normalizeAll applies n to each word of an all-words array, as mapFunc does
+func (n Normalization) normalizeAll(all []string) []string {
+	if n&NormalizeAll == NormalizeNone {
+		return all
+	}
+	return n.Words(strings.Join(all, " "))
+}
+
+// script returns the table handed to mapFunc as a flat list of characters
+// and their replacements, or nil if nothing is normalized. A list is used as
+// characters such as . and $ may not be document keys.
+func (n Normalization) script() (pairs []string) {
+	table := normalizeTables[n&NormalizeAll]
+	if len(table) == 0 {
+		return nil
+	}
+	chars := make([]string, 0, len(table))
+	for c := range table {
+		chars = append(chars, string(c))
+	}
+	sort.Strings(chars)
+	pairs = make([]string, 0, 2*len(chars))
+	for _, c := range chars {
+		pairs = append(pairs, c, table[[]rune(c)[0]])
+	}
+	return
+}
@@ -0,0 +1,164 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizationWords(t *testing.T) {
+	tests := []struct {
+		N     Normalization
+		Text  string
+		Words []string
+	}{
+		{NormalizeNone, "data-center, café", []string{"data-center,", "café"}},
+		{NormalizePunctuation, "data-center, café", []string{"data", "center", "café"}},
+		{NormalizePunctuation, "Google's “cloud” (beta)...", []string{"Googles", "cloud", "beta"}},
+		{NormalizePunctuation, "-- —", []string{}},
+		{NormalizeDiacritics, "Café naïve Ærø Łódź", []string{"Cafe", "naive", "Æro", "Lodz"}},
+		{NormalizeDiacritics, "café", []string{"cafe"}},
+		{NormalizeFold, "ﬁle Ｇｏｏｇｌｅ", []string{"file", "Google"}},
+		{NormalizeFold | NormalizeDiacritics, "ｃａｆé", []string{"cafe"}},
+		{NormalizeAll, "The café's data-center, opened.", []string{"The", "cafes", "data", "center", "opened"}},
+		{NormalizeAll, "日本 data", []string{"日本", "data"}},
+	}
+	for _, test := range tests {
+		if words := test.N.Words(test.Text); !reflect.DeepEqual(words, test.Words) {
+			t.Errorf("%d %q: expected %q, got %q", test.N, test.Text, test.Words, words)
+		}
+	}
+}
+
+func TestBuildQueryNormalized(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Expect string
+	}{
+		{
+			`published:2014-06-01 AND keywords:"data-center,"`,
+			`{"$or":[{"keywords":{"$all":["data","center"]},"pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:Café`,
+			`{"$or":[{"keywords":"Cafe","pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:"café* NEAR/2 data-center"`,
+			`{"$or":[{"keywords":{"$all":[{"Pattern":"^cafe","Options":""},"data","center"]},"pubdate":20140601}]}`,
+		},
+		{
+			`published:2014-06-01 AND keywords:dáta-cent?r`,
+			`{"$or":[{"keywords":{"$all":["data",{"Pattern":"^cent.r$","Options":""}]},"pubdate":20140601}]}`,
+		},
+	}
+	for _, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetNormalization(NormalizeAll)
+
+		query, err := parseQuery(test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Input, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Expect {
+			t.Errorf("Input:  %s", test.Input)
+			t.Errorf("Expect: %s", test.Expect)
+			t.Errorf("Got:    %s", b)
+		}
+	}
+
+	ms, _ := New("", "Items", "Results")
+	ms.SetKeyword("keywords", ConvertSpaces)
+	ms.SetPubdate("pubdate", ConvertDateInt, "published")
+	ms.SetNormalization(NormalizeAll)
+	query, _ := parseQuery(`published:2014-06-01 AND keywords:"--"`)
+	if _, err := ms.buildQuery(query); err == nil {
+		t.Error("Expected an error for a term of only punctuation")
+	}
+}
+
+func TestMapFuncNormalized(t *testing.T) {
+	text := "The Café's data-center, in Łódź, opened ﬁrst."
+	tests := []struct {
+		N      Normalization
+		Phrase string
+		Match  bool
+	}{
+		{NormalizeNone, "data center", false},
+		{NormalizeNone, "data-center,", true},
+		{NormalizeAll, "data center", true},
+		{NormalizeAll, "data-center", true},
+		{NormalizeAll, "cafes data center in lodz", true},
+		{NormalizeAll, "café's data", true},
+		{NormalizeAll, "opened first", true},
+		{NormalizeAll, "center lodz", false},
+		{NormalizePunctuation, "in lodz", false},
+		{NormalizeAll, "caf* NEAR/1 center", true},
+		{NormalizeAll, "/l.dz/ opened", true},
+	}
+	for _, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetNormalization(test.N)
+
+		query, err := parseQuery(`keywords:"` + test.Phrase + `"`)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		scope, err := ms.buildScope(query)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Phrase, err)
+		}
+		if match := runMapFunc(t, scope, text, false, test.N); match != test.Match {
+			t.Errorf("%d %q: mapFunc expected %v, got %v", test.N, test.Phrase, test.Match, match)
+		}
+		if match, _ := ms.Match(`keywords:"`+test.Phrase+`"`, strings.Fields(text)); match != test.Match {
+			t.Errorf("%d %q: Match expected %v, got %v", test.N, test.Phrase, test.Match, match)
+		}
+	}
+}
+
+func TestMemoryStoreNormalized(t *testing.T) {
+	text := "The Café's data-center, opened."
+	store := NewMemoryStore()
+	if err := store.Insert(memDoc{
+		Id:    1,
+		PubId: pubs[0],
+		Date:  time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC),
+		All:   strings.Fields(text),
+		Kws:   NormalizeAll.Words(text),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewWithStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+	s.SetNormalization(NormalizeAll)
+
+	for _, query := range []string{
+		`date:2014-06-01 AND keywords:"data center"`,
+		`date:2014-06-01 AND keywords:"Cafés data-center"`,
+	} {
+		id, err := s.Search(query)
+		if err != nil {
+			t.Fatalf("%s: %s", query, err)
+		}
+		if ids := memResults(t, s, id, ResultOptions{}); !reflect.DeepEqual(ids, []int{1}) {
+			t.Errorf("%s: expected [1], got %v", query, ids)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"strings"
+	"testing"
+)
+
+// phraseFixtures are checked against both mapFunc, run in a JavaScript
+// interpreter, and EvalScope
+var phraseFixtures = []struct {
+	Text   string
+	Phrase string
+	Match  bool
+}{
+	{"a b c", "a", true},
+	{"a b c", "c", true},
+	{"a b c", "d", false},
+	{"a b c", "a b c", true},
+	{"a b c", "b c", true},
+	{"a b c", "a b c d", false},
+	{"a b c", "c d", false},
+	{"a b c", "c b", false},
+	// Only a later occurrence of the first word starts the phrase
+	{"a x a b", "a b", true},
+	{"a x a x a b", "a b", true},
+	{"a x a x a x", "a b", false},
+	// Occurrences past the first word's index must not be skipped
+	{"x y a x a b", "a b", true},
+	{"x x x x a b", "a b", true},
+	{"b a a b", "a b", true},
+	{"a a a", "a a a", true},
+	{"a a", "a a a", false},
+	{"", "a", false},
+	{"Data Center", "data center", true},
+	{"big data center", "d*a cent?r", true},
+	{"big data center", "/big|small/ data", true},
+	{"big data center", "data /cent(er|re)/ room", false},
+	{"big data center", "big NEAR/1 center", true},
+	{"big data center", "center ONEAR/1 big", false},
+	// Regular expressions ignore case rather than being lowercased
+	{"123", `/\D+/`, false},
+	{"abc", `/\D+/`, true},
+	{"Big Data", "/[A-C]ig/ data", true},
+}
+
+// runMapFunc runs mapFunc over a document holding text and reports whether
+// it emitted
+func runMapFunc(t *testing.T, scope interface{}, text string, caseSensitive bool, n Normalization) bool {
+	vm := otto.New()
+	emitted := false
+	vm.Set("emit", func(call otto.FunctionCall) otto.Value {
+		emitted = true
+		return otto.UndefinedValue()
+	})
+	vm.Set("caseSensitive", caseSensitive)
+
+	query, _ := json.Marshal(scope)
+	normalize, _ := json.Marshal(n.script())
+	doc, _ := json.Marshal(map[string]interface{}{
+		"all":     strings.Fields(text),
+		"pubdate": 20140601,
+	})
+	src := fmt.Sprintf("query = %s;\nnormalize = %s;\n(%s).call(%s)", query, normalize, fmt.Sprintf(mapFunc, "all", "pubdate"), doc)
+	if _, err := vm.Run(src); err != nil {
+		t.Fatalf("mapFunc: %s", err)
+	}
+	return emitted
+}
+
+func TestMapFuncPhrases(t *testing.T) {
+	for _, test := range phraseFixtures {
+		scope := map[string]interface{}{"and": []interface{}{test.Phrase}}
+		if match := runMapFunc(t, scope, test.Text, false, NormalizeNone); match != test.Match {
+			t.Errorf("%q in %q: expected %v, got %v", test.Phrase, test.Text, test.Match, match)
+		}
+	}
+}
+
+func TestEvalScopePhrases(t *testing.T) {
+	for _, test := range phraseFixtures {
+		scope := map[string]interface{}{"and": []interface{}{test.Phrase}}
+		match, err := EvalScope(scope, strings.Fields(test.Text), false)
+		if err != nil {
+			t.Fatalf("%q: %s", test.Phrase, err)
+		}
+		if match != test.Match {
+			t.Errorf("%q in %q: expected %v, got %v", test.Phrase, test.Text, test.Match, match)
+		}
+	}
+}
+
+func TestMapFuncScope(t *testing.T) {
+	text := "The data center and the Google data room"
+	tests := []struct {
+		Scope         map[string]interface{}
+		CaseSensitive bool
+	}{
+		{map[string]interface{}{"and": []interface{}{"data center", "google"}}, false},
+		{map[string]interface{}{"and": []interface{}{"data center", "google"}}, true},
+		{map[string]interface{}{"or": []interface{}{"cloud", "data room"}}, false},
+		{map[string]interface{}{"nor": []interface{}{"cloud", "google data"}}, false},
+		{
+			map[string]interface{}{
+				"and": []interface{}{map[string]interface{}{"or": []interface{}{"cdw", "google"}}},
+				"nor": []interface{}{map[string]interface{}{"and": []interface{}{"the data room"}}},
+			},
+			false,
+		},
+	}
+	for i, test := range tests {
+		expect, err := EvalScope(test.Scope, strings.Fields(text), test.CaseSensitive)
+		if err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+		if match := runMapFunc(t, test.Scope, text, test.CaseSensitive, NormalizeNone); match != expect {
+			t.Errorf("[%d] mapFunc gave %v, EvalScope gave %v", i, match, expect)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package mongosearch
+
+import (
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// doAggregate writes the items matching job.Query into the results collection
+// for job.Id using an aggregation pipeline. The output matches what
+// doMapReduce produces with mapFuncImmediate. Only the output count and time
+// are filled in on the returned info.
+func (m *mgoStore) doAggregate(session *mgo.Session, job *Job) (info *mgo.MapReduceInfo, err error) {
+	start := time.Now()
+
+	outDb, outColl := m.s.resultsFor(session, job.Id)
+	db, coll := m.s.dbFor(session, m.s.CollItems)
+
+	// The document form of $out is needed to write across databases
+	var out interface{} = outColl
+	if outDb != db {
+		out = bson.M{"db": outDb, "coll": outColl}
+	}
+
+	pipeline := aggregatePipeline(job, out)
+
+	// Run directly rather than through Pipe, which does not ask for a
+	// cursor as newer servers require. The comment lets currentOps find it.
+	if err = session.DB(db).Run(bson.D{
+		{Name: "aggregate", Value: coll},
+		{Name: "pipeline", Value: pipeline},
+		{Name: "cursor", Value: bson.M{}},
+		{Name: "allowDiskUse", Value: true},
+		{Name: "comment", Value: outColl},
+	}, nil); err != nil {
+		return
+	}
+
+	info = new(mgo.MapReduceInfo)
+	if info.OutputCount, err = session.DB(outDb).C(outColl).Count(); err != nil {
+		return nil, err
+	}
+	info.Time = time.Since(start).Nanoseconds()
+	return
+}
+
+// aggregatePipeline returns the stages writing the items matching job.Query
+// into out. None of them run JavaScript.
+func aggregatePipeline(job *Job, out interface{}) []bson.M {
+	return []bson.M{
+		{"$match": job.Query},
+		{"$project": bson.M{
+			"_id": 1,
+			"value": bson.M{
+				"pubdate": "$" + job.Pubdate,
+			},
+		}},
+		{"$out": out},
+	}
+}
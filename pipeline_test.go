@@ -0,0 +1,95 @@
+package mongosearch
+
+import (
+	"context"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateMatchesMapReduce(t *testing.T) {
+	if *ServerAddr == "" {
+		t.Skip("No mongo server provided")
+	}
+
+	resetDB(t)
+
+	s, err := New(*ServerAddr, "Items", "Results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+
+	ids := func(backend Backend) (found []bson.ObjectId) {
+		s.SetBackend(backend)
+		id, err := s.Search("date:2014-06-02 AND (a OR e)")
+		if err != nil {
+			t.Fatalf("%s: %s", backend, err)
+		}
+		it, err := s.Results(id, ResultOptions{Sort: 1})
+		if err != nil {
+			t.Fatalf("%s: %s", backend, err)
+		}
+		var result struct {
+			Id bson.ObjectId `bson:"_id"`
+		}
+		for it.Next(&result) {
+			found = append(found, result.Id)
+		}
+		if err := it.Close(); err != nil {
+			t.Fatalf("%s: %s", backend, err)
+		}
+		return
+	}
+
+	mr, agg := ids(BackendMapReduce), ids(BackendAggregate)
+	if len(mr) == 0 || !reflect.DeepEqual(mr, agg) {
+		t.Errorf("Results differ\nmapreduce: %v\naggregate: %v", mr, agg)
+	}
+}
+
+// jobStore keeps the last job it was given
+type jobStore struct {
+	*MemoryStore
+	job *Job
+}
+
+func (j *jobStore) Execute(ctx context.Context, job *Job) (info interface{}, err error) {
+	j.job = job
+	return j.MemoryStore.Execute(ctx, job)
+}
+
+func TestNoJavaScriptBackend(t *testing.T) {
+	s, store := newMemorySearch(t)
+	jobs := &jobStore{MemoryStore: store}
+	s.store = jobs
+	s.SetJavaScript(false)
+
+	id, err := s.Search("date:2014-06-02 AND keywords:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs.job.Backend != BackendAggregate || jobs.job.MapReduce {
+		t.Errorf("Expected the %s backend without map-reduce, got %s (map-reduce %v)", BackendAggregate, jobs.job.Backend, jobs.job.MapReduce)
+	}
+	var meta struct {
+		Backend string
+	}
+	if err := store.Meta(id, &meta); err != nil || meta.Backend != BackendAggregate.String() {
+		t.Errorf("Expected %s recorded, got %q (%v)", BackendAggregate, meta.Backend, err)
+	}
+
+	var stages []string
+	for _, stage := range aggregatePipeline(jobs.job, "out") {
+		for op := range stage {
+			stages = append(stages, op)
+		}
+	}
+	if expect := []string{"$match", "$project", "$out"}; !reflect.DeepEqual(stages, expect) {
+		t.Errorf("Expected stages %v, got %v", expect, stages)
+	}
+}
@@ -0,0 +1,97 @@
+package mongosearch
+
+import (
+	"github.com/300brand/logger"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// Retention limits how long search results are kept. Zero values disable the
+// corresponding limit.
+type Retention struct {
+	MaxAge   time.Duration // Expire searches older than this
+	MaxCount int           // Keep only this many of the newest searches
+}
+
+// SetRetention sets the policy applied by Expire and the janitor
+func (s *MongoSearch) SetRetention(r Retention) {
+	s.retention = r
+}
+
+// Purge drops the results collections of finished searches created before
+// olderThan and marks their metadata documents as expired. The age of a
+// search is taken from its id. Returns the number of searches expired.
+func (s *MongoSearch) Purge(olderThan time.Time) (n int, err error) {
+	return s.purge(bson.M{"_id": bson.M{"$lt": bson.NewObjectIdWithTime(olderThan)}}, 0)
+}
+
+// Expire applies the retention policy, returning the number of searches
+// expired
+func (s *MongoSearch) Expire() (n int, err error) {
+	if s.retention.MaxAge > 0 {
+		if n, err = s.Purge(time.Now().Add(-s.retention.MaxAge)); err != nil {
+			return
+		}
+	}
+	if s.retention.MaxCount > 0 {
+		var m int
+		m, err = s.purge(bson.M{}, s.retention.MaxCount)
+		n += m
+	}
+	return
+}
+
+// StartJanitor runs Expire every interval in the background until Close is
+// called
+func (s *MongoSearch) StartJanitor(interval time.Duration) {
+	s.shared.Lock()
+	ctx := s.shared.ctx
+	s.shared.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if n, err := s.Expire(); err != nil {
+				logger.Error.Printf("Janitor: %s", err)
+			} else if n > 0 {
+				logger.Info.Printf("Janitor: expired %d searches", n)
+			}
+		}
+	}()
+}
+
+// purge expires the searches matching filter, newest first, after skipping
+// the first skip of them. Queued and running searches count towards skip but
+// are left alone.
+func (s *MongoSearch) purge(filter bson.M, skip int) (n int, err error) {
+	filter["status"] = bson.M{"$ne": StatusExpired}
+	var searches []SearchStatus
+	if err = s.store.Searches(filter, skip, &searches); err != nil {
+		return
+	}
+
+	for _, search := range searches {
+		switch search.Status {
+		case StatusQueued, StatusRunning:
+			continue
+		}
+
+		if err = s.store.Drop(search.Id); err != nil {
+			return
+		}
+		if err = s.store.SetMeta(search.Id, bson.M{
+			"status":    StatusExpired,
+			"expiredAt": time.Now(),
+		}); err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package mongosearch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurge(t *testing.T) {
+	if *ServerAddr == "" {
+		t.Skip("No mongo server provided")
+	}
+
+	resetDB(t)
+
+	s, err := New(*ServerAddr, "Items", "Results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+
+	id, err := s.Search("date:2014-06-02 AND a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.Purge(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	} else if status, _ := s.Status(id); status.Status == StatusExpired {
+		t.Fatalf("Search expired too early (%d purged)", n)
+	}
+
+	if n, err := s.Purge(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	} else if n == 0 {
+		t.Fatal("Nothing purged")
+	}
+
+	status, err := s.Status(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != StatusExpired {
+		t.Errorf("Expected status %s, got %s", StatusExpired, status.Status)
+	}
+}
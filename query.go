@@ -5,12 +5,54 @@ import (
 	"github.com/300brand/logger"
 	"github.com/300brand/searchquery"
 	"labix.org/v2/mgo/bson"
-	"time"
+	"regexp"
+	"strings"
 )
 
+// rangeExpr matches the field:[X TO Y] range form. Either bound may be * to
+// leave that end open.
+var rangeExpr = regexp.MustCompile(`([\w.]+):\[\s*('[^']*'|"[^"]*"|[^\s\]]+)\s+TO\s+('[^']*'|"[^"]*"|[^\s\]]+)\s*\]`)
+
+// rangeOrPhrase matches either a range or a quoted phrase, so ranges are only
+// found outside of phrases
+var rangeOrPhrase = regexp.MustCompile(`"[^"]*"|` + rangeExpr.String())
+
+// parseQuery parses query after rewriting any range expressions into a pair of
+// bounds the parser understands
+func parseQuery(query string) (*searchquery.Query, error) {
+	query = rangeOrPhrase.ReplaceAllStringFunc(query, func(expr string) string {
+		if strings.HasPrefix(expr, `"`) {
+			return expr
+		}
+		m := rangeExpr.FindStringSubmatch(expr)
+		field, from, to := m[1], m[2], m[3]
+		switch {
+		case from == "*":
+			return fmt.Sprintf("(%s<=%s)", field, to)
+		case to == "*":
+			return fmt.Sprintf("(%s>=%s)", field, from)
+		}
+		return fmt.Sprintf("(%s>=%s AND %s<=%s)", field, from, field, to)
+	})
+	return searchquery.ParseGreedy(query)
+}
+
 func (s *MongoSearch) buildQuery(query *searchquery.Query) (mgoQuery bson.M, err error) {
 	// logger.Info.Printf("buildQuery: starting with %s", query)
 
+	if err = s.dropEmptyTerms(query); err != nil {
+		return
+	}
+	if s.expansions, err = s.expandSynonyms(query); err != nil {
+		return
+	}
+	if err = s.resolveDates(query); err != nil {
+		return
+	}
+	if err = s.checkWildcards(query); err != nil {
+		return
+	}
+
 	fields := s.mapFields(query)
 
 	keywordSubquery, ok := fields[s.fields.keyword]
@@ -18,16 +60,30 @@ func (s *MongoSearch) buildQuery(query *searchquery.Query) (mgoQuery bson.M, err
 		err = fmt.Errorf("No field found for %s", s.fields.keyword)
 		return
 	}
-	dateSubquery, ok := fields[s.fields.pubdate]
-	if !ok {
-		dateSubquery = searchquery.SubQuery{
-			Value:    time.Now().Format(TimeLayout),
-			Field:    s.fields.pubdate,
-			Operator: searchquery.OperatorRelE,
+	dateSubqueries := s.fieldSubqueries(query, s.fields.pubdate)
+	if len(dateSubqueries) == 0 {
+		if dateSubqueries, err = s.windowSubqueries(); err != nil {
+			return
 		}
 	}
 
-	reduced := s.reduce(keywordSubquery)
+	reduced, excluded := s.reduceExcluded(keywordSubquery)
+	excluded = append(excluded, reduced.Excluded...)
+	outer, err := s.outerExcluded(query)
+	if err != nil {
+		return
+	}
+	excluded = append(excluded, outer...)
+
+	// Simple exclusions go straight into the filter; anything fuzzier (such as
+	// a phrase) is left for the map function to weed out
+	exclude, exact, err := s.convertExcluded(excluded)
+	if err != nil {
+		return
+	}
+	if !exact {
+		s.reqMapReduce = true
+	}
 
 	subqueries := make([]searchquery.SubQuery, 0, len(reduced.Optional)+len(reduced.Required))
 	subqueries = append(subqueries, reduced.Optional...)
@@ -41,74 +97,62 @@ func (s *MongoSearch) buildQuery(query *searchquery.Query) (mgoQuery bson.M, err
 	// Convert values
 	convertedFields := make(map[string]bson.M, len(fields))
 	for fName, f := range fields {
-		convertedFields[fName], err = s.convertSubquery(&f)
+		subs := s.fieldSubqueries(query, fName)
+		if len(subs) == 0 {
+			subs = append(subs, f)
+		}
+		convertedFields[fName], err = s.convertBounds(subs)
 		if err != nil {
 			logger.Error.Printf("buildQuery: %s", err)
 			return
 		}
 	}
 
-	dateValue, err := s.convertSubquery(&dateSubquery)
+	dateClause, err := s.convertBounds(dateSubqueries)
 	if err != nil {
 		return
 	}
 
-	// This is a pain in the ass..
-	var dates []interface{}
+	mgoSubs := make([]bson.M, len(subqueries))
+	for idx, subquery := range subqueries {
+		mgoSubs[idx] = make(bson.M, len(convertedFields)+1)
 
-	dateIn, ok := dateValue[s.fields.pubdate]
-	if !ok {
-		return nil, fmt.Errorf("dateValue has no field %s: %#v", s.fields.pubdate, dateValue)
-	}
+		// Set date
+		mergeClause(mgoSubs[idx], dateClause)
 
-	switch t := dateIn.(type) {
-	case bson.M:
-		if dates, ok = t["$in"].([]interface{}); !ok {
-			return nil, fmt.Errorf("Crazy setup in the dateIn struct %#v", dateIn)
-		}
-	case interface{}:
-		dates = []interface{}{t}
-	default:
-		return nil, fmt.Errorf("Weird problem with dateIn arr... %#v", dateIn)
-	}
-
-	mgoSubs := make([]bson.M, len(subqueries)*len(dates))
-	for i, date := range dates {
-		for j, subquery := range subqueries {
-			idx := i*len(subqueries) + j
-			mgoSubs[idx] = make(bson.M, len(convertedFields)+1)
-
-			// Set date
-			mgoSubs[idx][s.fields.pubdate] = date
-
-			// logger.Warn.Printf("%#v", subquery)
-			// Process the keywords
-			if subquery.Operator == searchquery.OperatorSubquery {
-				value, err := s.convertQuery(s.reduce(subquery))
-				if err != nil {
-					return nil, err
-				}
-				for k := range value {
-					mgoSubs[idx][k] = value[k]
-				}
-				// logger.Error.Printf("convertQuery: %#v", value)
-			} else {
-				value, err := s.convertSubquery(&subquery)
-				if err != nil {
-					return nil, err
-				}
-				// logger.Warn.Printf("%#v", value)
-				for k := range value {
-					mgoSubs[idx][k] = value[k]
-				}
+		// logger.Warn.Printf("%#v", subquery)
+		// Process the keywords
+		if subquery.Operator == searchquery.OperatorSubquery {
+			subReduced, subExcluded := s.reduceExcluded(subquery)
+			value, err := s.convertQuery(subReduced)
+			if err != nil {
+				return nil, err
 			}
-
-			// Push in remaining fields
-			// TODO cleanup
-			for k, v := range convertedFields {
-				mgoSubs[idx][k] = v[k]
+			mergeClause(mgoSubs[idx], value)
+			subExclude, subExact, err := s.convertExcluded(subExcluded)
+			if err != nil {
+				return nil, err
 			}
+			if !subExact {
+				s.reqMapReduce = true
+			}
+			mergeClause(mgoSubs[idx], subExclude)
+			// logger.Error.Printf("convertQuery: %#v", value)
+		} else {
+			value, err := s.convertSubquery(&subquery)
+			if err != nil {
+				return nil, err
+			}
+			// logger.Warn.Printf("%#v", value)
+			mergeClause(mgoSubs[idx], value)
+		}
+
+		// Push in remaining fields
+		for _, v := range convertedFields {
+			mergeClause(mgoSubs[idx], v)
 		}
+
+		mergeClause(mgoSubs[idx], exclude)
 	}
 	mgoQuery = bson.M{"$or": mgoSubs}
 
@@ -153,14 +197,132 @@ func (s *MongoSearch) mapFields(query *searchquery.Query) (fields map[string]sea
 	return
 }
 
+// fieldSubqueries collects every subquery on field found where mapFields would
+// look for one, so bounds given separately combine into a single range.
+// Alternatives on field are kept together in a group of their own, as they
+// must not be combined with the rest.
+func (s *MongoSearch) fieldSubqueries(query *searchquery.Query, field string) (found []searchquery.SubQuery) {
+	for _, sq := range query.Required {
+		found = append(found, s.fieldMatches(sq, field)...)
+	}
+
+	var alternatives []searchquery.SubQuery
+	for _, sq := range query.Optional {
+		switch matches := s.fieldMatches(sq, field); len(matches) {
+		case 0:
+		case 1:
+			alternatives = append(alternatives, matches[0])
+		default:
+			alternatives = append(alternatives, searchquery.SubQuery{
+				Operator: searchquery.OperatorSubquery,
+				Query:    &searchquery.Query{Required: matches},
+			})
+		}
+	}
+	switch len(alternatives) {
+	case 0:
+	case 1:
+		found = append(found, alternatives[0])
+	default:
+		found = append(found, searchquery.SubQuery{
+			Operator: searchquery.OperatorSubquery,
+			Query:    &searchquery.Query{Optional: alternatives},
+		})
+	}
+	return
+}
+
+// fieldMatches returns the subqueries on field within sq, all of which apply
+func (s *MongoSearch) fieldMatches(sq searchquery.SubQuery, field string) []searchquery.SubQuery {
+	name := sq.Field
+	if newName, ok := s.Rewrites[name]; ok {
+		name = newName
+	}
+	switch {
+	case name == field && sq.Query != nil && len(sq.Query.Optional)+len(sq.Query.Excluded) == 0:
+		// Groups of bounds, as relative dates become, join the others
+		return sq.Query.Required
+	case name == field:
+		return []searchquery.SubQuery{sq}
+	case name == "" && sq.Query != nil:
+		return s.fieldSubqueries(sq.Query, field)
+	}
+	return nil
+}
+
+// convertBounds converts subqueries on a single field into one clause. Bounds
+// such as $gte and $lte share one operator document; anything else that
+// collides is combined under $and.
+func (s *MongoSearch) convertBounds(subqueries []searchquery.SubQuery) (mgoQuery bson.M, err error) {
+	mgoQuery = bson.M{}
+	for i := range subqueries {
+		converted, err := s.convertSubquery(&subqueries[i])
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range converted {
+			if ops, ok := mgoQuery[k].(bson.M); ok && isOperatorDoc(ops) {
+				if bounds, ok := v.(bson.M); ok && isOperatorDoc(bounds) && !overlaps(ops, bounds) {
+					for op := range bounds {
+						ops[op] = bounds[op]
+					}
+					continue
+				}
+			}
+			mergeClause(mgoQuery, bson.M{k: v})
+		}
+	}
+	return
+}
+
+// outerExcluded collects the exclusions of query and of the blank-field groups
+// it requires, which apply to the query as a whole. Keyword exclusions within
+// an OR'd group are left to the map function, while those on other fields
+// cannot be applied at all and are rejected.
+func (s *MongoSearch) outerExcluded(query *searchquery.Query) (excluded []searchquery.SubQuery, err error) {
+	excluded = append(excluded, query.Excluded...)
+	for _, sq := range query.Required {
+		if sq.Field == "" && sq.Query != nil {
+			inner, err := s.outerExcluded(sq.Query)
+			if err != nil {
+				return nil, err
+			}
+			excluded = append(excluded, inner...)
+		}
+	}
+	for _, sq := range query.Optional {
+		if sq.Field == "" && sq.Query != nil {
+			inner, err := s.outerExcluded(sq.Query)
+			if err != nil {
+				return nil, err
+			}
+			for i := range inner {
+				if s.isForeign(&inner[i]) {
+					return nil, fmt.Errorf("Cannot exclude %s within an OR group: only %s may be excluded there", inner[i], s.fields.keyword)
+				}
+				// Applying to this branch alone, it is left to the scope
+				s.reqMapReduce = true
+			}
+		}
+	}
+	return
+}
+
 func (s *MongoSearch) reduce(subquery searchquery.SubQuery) (reduced *searchquery.Query) {
+	reduced, _ = s.reduceExcluded(subquery)
+	return
+}
+
+// reduceExcluded works like reduce, additionally returning the exclusions of
+// every level collapsed on the way down. Exclusions on the returned query
+// itself are left in place.
+func (s *MongoSearch) reduceExcluded(subquery searchquery.SubQuery) (reduced *searchquery.Query, excluded []searchquery.SubQuery) {
 	for subquery.Operator == searchquery.OperatorSubquery {
-		reduced = subquery.Query
-		if len(reduced.Excluded) > 0 {
-			// logger.Info.Printf("reduce: Enabling MapReduce because Excluded > 0 (%d)", len(reduced.Excluded))
-			s.reqMapReduce = true
+		if reduced != nil {
+			excluded = append(excluded, reduced.Excluded...)
 		}
-		if len(reduced.Optional)+len(reduced.Required) > 1 {
+		reduced = subquery.Query
+		if len(reduced.Optional)+len(reduced.Required) != 1 {
 			break
 		}
 
@@ -184,7 +346,8 @@ func (s *MongoSearch) reduce(subquery searchquery.SubQuery) (reduced *searchquer
 			continue
 		}
 		for i := range optreq {
-			for optreq[i].Operator == searchquery.OperatorSubquery {
+			// Groups carrying exclusions must stay intact
+			for optreq[i].Operator == searchquery.OperatorSubquery && len(optreq[i].Query.Excluded) == 0 {
 				if subs := optreq[i].Query.Optional; len(subs) == 1 {
 					optreq[i] = subs[0]
 				} else if len(subs) > 1 {
@@ -212,12 +375,216 @@ func (s *MongoSearch) convertQuery(query *searchquery.Query) (mgoQuery bson.M, e
 	if err = s.loopSubqueries(query.Optional, "$or", mgoQuery); err != nil {
 		return
 	}
-	// if err = s.loopSubqueries(query.Excluded, "$nor", mgoQuery); err != nil {
-	// 	return
-	// }
+	exclude, exact, err := s.convertExcluded(query.Excluded)
+	if err != nil {
+		return
+	}
+	if !exact {
+		// logger.Info.Printf("convertQuery: Enabling MapReduce for inexact exclusions")
+		s.reqMapReduce = true
+	}
+	mergeClause(mgoQuery, exclude)
+	return
+}
+
+// convertExcluded builds a filter rejecting anything matched by the excluded
+// subqueries. exact is false when an exclusion cannot be expressed precisely,
+// in which case it is left out of the filter and the map function must apply
+// it.
+func (s *MongoSearch) convertExcluded(all []searchquery.SubQuery) (mgoQuery bson.M, exact bool, err error) {
+	exact = true
+	excluded := make([]searchquery.SubQuery, 0, len(all))
+	for i := range all {
+		ok, err := s.isExact(&all[i])
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			exact = false
+			continue
+		}
+		excluded = append(excluded, all[i])
+	}
+	if len(excluded) == 0 {
+		return
+	}
+
+	// The map function would have compared words case-insensitively
+	defer func() {
+		if !s.caseSensitive {
+			s.foldCase(mgoQuery)
+		}
+	}()
+
+	if s.canOptimize(excluded) {
+		var field string
+		if len(excluded) == 1 {
+			var value interface{}
+			field, value, _, err = s.realValue(&excluded[0])
+			mgoQuery = bson.M{field: bson.M{"$ne": value}}
+			return
+		}
+
+		values := make([]interface{}, len(excluded))
+		for i := range excluded {
+			field, values[i], _, _ = s.realValue(&excluded[i])
+		}
+		mgoQuery = bson.M{field: bson.M{"$nin": values}}
+		return
+	}
+
+	nor := make([]bson.M, 0, len(excluded))
+	for _, sq := range excluded {
+		built, err := s.convertSubquery(&sq)
+		if err != nil {
+			return nil, false, err
+		}
+		nor = append(nor, built)
+	}
+	mgoQuery = bson.M{"$nor": nor}
 	return
 }
 
+// foldCase rewrites the words compared on the keyword and shingle fields of
+// clause into anchored, case-insensitive regular expressions
+func (s *MongoSearch) foldCase(clause bson.M) {
+	for k, v := range clause {
+		switch k {
+		case "$and", "$or", "$nor":
+			subs, _ := v.([]bson.M)
+			for _, sub := range subs {
+				s.foldCase(sub)
+			}
+		case s.fields.keyword, s.fields.shingle:
+			if k != "" {
+				clause[k] = foldValue(v)
+			}
+		}
+	}
+}
+
+// foldValue makes value, or the operands of an operator document, match
+// case-insensitively
+func foldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return bson.RegEx{Pattern: "^" + regexp.QuoteMeta(v) + "$", Options: "i"}
+	case bson.RegEx:
+		if !strings.Contains(v.Options, "i") {
+			v.Options += "i"
+		}
+		return v
+	case []string:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = foldValue(v[i])
+		}
+		return list
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = foldValue(v[i])
+		}
+		return list
+	case bson.M:
+		ops := bson.M{}
+		for op, arg := range v {
+			switch op {
+			case "$ne":
+				ops["$not"] = foldValue(arg)
+			case "$regex":
+				options, _ := v["$options"].(string)
+				if !strings.Contains(options, "i") {
+					options += "i"
+				}
+				ops[op], ops["$options"] = arg, options
+			case "$options":
+				if _, ok := v["$regex"]; !ok {
+					ops[op] = arg
+				}
+			default:
+				ops[op] = foldValue(arg)
+			}
+		}
+		return ops
+	}
+	return value
+}
+
+// isExact reports whether subquery converts to a filter matching precisely
+// what the map function would. Keyword phrases only qualify when shingles
+// cover them.
+func (s *MongoSearch) isExact(subquery *searchquery.SubQuery) (exact bool, err error) {
+	if q := subquery.Query; q != nil {
+		for _, subs := range [][]searchquery.SubQuery{q.Required, q.Optional, q.Excluded} {
+			for i := range subs {
+				if exact, err = s.isExact(&subs[i]); err != nil || !exact {
+					return
+				}
+			}
+		}
+		return true, nil
+	}
+
+	field, out, err := s.convertValue(subquery)
+	if err != nil || out.Kind != ValueAll || field != s.fields.keyword {
+		return err == nil, err
+	}
+	// Word pairs say nothing about words merely near each other
+	if !isNear(subquery.Value) {
+		_, exact = s.shingle(field, out.Value)
+	}
+	if !exact && s.noJavaScript {
+		err = fmt.Errorf("Cannot exclude %q exactly without server-side JavaScript, which is disabled", subquery.Value)
+	}
+	return
+}
+
+// isForeign reports whether subquery refers to any field besides the keyword
+// field. The map function has no way to evaluate those.
+func (s *MongoSearch) isForeign(subquery *searchquery.SubQuery) bool {
+	if q := subquery.Query; q != nil {
+		for _, subs := range [][]searchquery.SubQuery{q.Required, q.Optional, q.Excluded} {
+			for i := range subs {
+				if s.isForeign(&subs[i]) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	field := subquery.Field
+	if newName, ok := s.Rewrites[field]; ok {
+		field = newName
+	}
+	return field != s.fields.keyword
+}
+
+// overlaps reports whether a and b have any keys in common
+func overlaps(a, b bson.M) bool {
+	for k := range a {
+		if _, ok := b[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeClause adds the fields of clause to into. Fields already set in into are
+// combined under $and rather than overwritten.
+func mergeClause(into, clause bson.M) {
+	for k, v := range clause {
+		if _, ok := into[k]; !ok {
+			into[k] = v
+			continue
+		}
+		// Copied, as into may share its $and with other clauses
+		and, _ := into["$and"].([]bson.M)
+		into["$and"] = append(append([]bson.M{}, and...), bson.M{k: v})
+	}
+}
+
 func (s *MongoSearch) convertSubquery(subquery *searchquery.SubQuery) (mgoSubquery bson.M, err error) {
 	// logger.Trace.Printf("buildSubquery: %s %s %s", subquery.Field, subquery.Operator, subquery.Value)
 
@@ -225,15 +592,42 @@ func (s *MongoSearch) convertSubquery(subquery *searchquery.SubQuery) (mgoSubque
 		return s.convertQuery(subquery.Query)
 	}
 
-	errInvalidOp := "Cannot use %s operator with an array value for %s"
-
-	field, value, isArray, err := s.realValue(subquery)
+	field, out, err := s.convertValue(subquery)
 	if err != nil {
 		return
 	}
+
+	switch out.Kind {
+	case ValuePattern:
+		return s.convertPattern(subquery, field, out.Value)
+	case ValueAny:
+		return s.convertAny(subquery, field, out.Value)
+	case ValueRange:
+		return s.convertRange(subquery, field, out.From, out.To)
+	}
+
+	errInvalidOp := "Cannot use %s operator with an array value for %s"
+
+	value, isArray := out.Value, out.Kind == ValueAll
 	if isArray {
-		// logger.Info.Printf("convertSubquery: Enabling MapReduce because '%#v' is array", value)
-		s.reqMapReduce = true
+		// Word pairs say nothing about words merely near each other
+		if shingles, exact := s.shingle(field, value); shingles != nil && !isNear(subquery.Value) {
+			field, value, isArray = s.fields.shingle, shingles, len(shingles) > 1
+			if !isArray {
+				value = shingles[0]
+			}
+			if !exact {
+				// Pairs alone would let through items holding them apart
+				if s.noJavaScript {
+					err = fmt.Errorf("Phrase %q is longer than two words and needs server-side JavaScript, which is disabled", subquery.Value)
+					return
+				}
+				s.reqMapReduce = true
+			}
+		} else {
+			// logger.Info.Printf("convertSubquery: Enabling MapReduce because '%#v' is array", value)
+			s.reqMapReduce = true
+		}
 	}
 
 	// Wrap value in proper operator
@@ -283,24 +677,94 @@ func (s *MongoSearch) convertSubquery(subquery *searchquery.SubQuery) (mgoSubque
 	return
 }
 
+// convertPattern builds the clause for a wildcard or regular expression term
+func (s *MongoSearch) convertPattern(subquery *searchquery.SubQuery, field string, pattern interface{}) (mgoSubquery bson.M, err error) {
+	switch subquery.Operator {
+	case searchquery.OperatorRelE, searchquery.OperatorField:
+		mgoSubquery = bson.M{field: bson.M{"$regex": pattern}}
+	case searchquery.OperatorRelNE:
+		p, _ := pattern.(string)
+		mgoSubquery = bson.M{field: bson.M{"$not": bson.RegEx{Pattern: p}}}
+	default:
+		err = fmt.Errorf("Cannot use %s operator with a pattern for %s", subquery.Operator, field)
+	}
+	return
+}
+
+// convertAny builds the clause for a converted list of alternatives
+func (s *MongoSearch) convertAny(subquery *searchquery.SubQuery, field string, values interface{}) (mgoSubquery bson.M, err error) {
+	switch subquery.Operator {
+	case searchquery.OperatorRelE, searchquery.OperatorField:
+		mgoSubquery = bson.M{field: bson.M{"$in": values}}
+	case searchquery.OperatorRelNE:
+		mgoSubquery = bson.M{field: bson.M{"$nin": values}}
+	default:
+		err = fmt.Errorf("Cannot use %s operator with a list of values for %s", subquery.Operator, field)
+	}
+	return
+}
+
+// convertRange builds the clause for a converted range [from, to). The
+// operator applies to the range as a whole, so >= X takes everything from the
+// start of X and > X everything after its end.
+func (s *MongoSearch) convertRange(subquery *searchquery.SubQuery, field string, from, to interface{}) (mgoSubquery bson.M, err error) {
+	bounds := bson.M{}
+	if from != nil {
+		bounds["$gte"] = from
+	}
+	if to != nil {
+		bounds["$lt"] = to
+	}
+
+	var op string
+	var bound interface{}
+	switch subquery.Operator {
+	case searchquery.OperatorRelE, searchquery.OperatorField:
+		mgoSubquery = bson.M{field: bounds}
+		return
+	case searchquery.OperatorRelNE:
+		mgoSubquery = bson.M{field: bson.M{"$not": bounds}}
+		return
+	case searchquery.OperatorRelGTE:
+		op, bound = "$gte", from
+	case searchquery.OperatorRelGT:
+		op, bound = "$gte", to
+	case searchquery.OperatorRelLT:
+		op, bound = "$lt", from
+	case searchquery.OperatorRelLTE:
+		op, bound = "$lt", to
+	default:
+		err = fmt.Errorf("Unknown operator: %s", subquery.Operator)
+		return
+	}
+	if bound == nil {
+		err = fmt.Errorf("Cannot use %s operator with an open range for %s", subquery.Operator, field)
+		return
+	}
+	mgoSubquery = bson.M{field: bson.M{op: bound}}
+	return
+}
+
 func (s *MongoSearch) canOptimize(subqueries []searchquery.SubQuery) bool {
 	if len(subqueries) == 0 {
 		return false
 	}
 
-	field, _, _, _ := s.realValue(&subqueries[0])
+	field, _, _ := s.convertValue(&subqueries[0])
 	for _, sq := range subqueries {
 		if sq.Query != nil {
 			// logger.Trace.Printf("canOptimize: sq.Query != nil")
 			return false
 		}
 
-		var err error
-		var isArray bool
-		sqField := sq.Field
+		if sq.Operator != searchquery.OperatorField && sq.Operator != searchquery.OperatorRelE {
+			return false
+		}
 
-		if newName, ok := s.Rewrites[sqField]; ok {
-			sqField = newName
+		sqField, out, err := s.convertValue(&sq)
+		if err != nil {
+			// logger.Trace.Printf("canOptimize: %s returned error - %s", sqField, err)
+			return false
 		}
 
 		if field != sqField {
@@ -308,15 +772,8 @@ func (s *MongoSearch) canOptimize(subqueries []searchquery.SubQuery) bool {
 			return false
 		}
 
-		if convertFunc, ok := s.Conversions[sqField]; ok {
-			if _, isArray, err = convertFunc(sq.Value); err != nil {
-				// logger.Trace.Printf("canOptimize: %s returned error - %s", sqField, err)
-				return false
-			}
-		}
-
-		if isArray {
-			// logger.Trace.Printf("canOptimize: %s is array", sq)
+		if out.Kind != ValueScalar {
+			// logger.Trace.Printf("canOptimize: %s is not scalar", sq)
 			return false
 		}
 	}
@@ -367,19 +824,64 @@ func (s *MongoSearch) loopSubqueries(subqueries []searchquery.SubQuery, op strin
 }
 
 func (s *MongoSearch) realValue(subquery *searchquery.SubQuery) (field string, value interface{}, isArray bool, err error) {
+	field, out, err := s.convertValue(subquery)
+	if err != nil {
+		return
+	}
+	switch out.Kind {
+	case ValueScalar:
+		value = out.Value
+	case ValueAll:
+		value, isArray = out.Value, true
+	default:
+		err = fmt.Errorf("Expected a single value or phrase for %s", field)
+	}
+	return
+}
+
+// convertValue runs the converter for the field of subquery, if any. Without
+// one the value is used as is.
+func (s *MongoSearch) convertValue(subquery *searchquery.SubQuery) (field string, out Converted, err error) {
 	field = subquery.Field
-	value = subquery.Value
+	out.Value = subquery.Value
 
 	if newName, ok := s.Rewrites[field]; ok {
 		field = newName
 	}
 
-	if convertFunc, ok := s.Conversions[field]; ok {
-		if value, isArray, err = convertFunc(subquery.Value); err != nil {
-			err = fmt.Errorf("Error converting %s: %s", field, err)
+	value := subquery.Value
+	if field == s.fields.keyword {
+		if value, err = s.analyzeTerms(value); err != nil {
 			return
 		}
+		out.Value = value
 	}
 
+	if converter, ok := s.Conversions[field]; ok {
+		c := &Conversion{
+			Field:    field,
+			SubQuery: *subquery,
+			Location: s.location,
+			Layouts:  s.dateLayouts(),
+			Now:      s.now(),
+		}
+		c.SubQuery.Value = value
+		if field == s.fields.pubdate {
+			c.Time = s.dates[value]
+		}
+		if out, err = converter.Convert(c); err != nil {
+			err = &ConversionError{Field: field, Value: subquery.Value, Err: err}
+			return
+		}
+	}
+
+	if field == s.fields.keyword {
+		if _, ok, err := parseNear(value); err != nil {
+			return field, out, err
+		} else if ok {
+			out = nearWords(out)
+		}
+		out, err = patternValue(out)
+	}
 	return
 }
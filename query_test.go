@@ -115,3 +115,253 @@ func TestReduce(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildQueryExcluded(t *testing.T) {
+	tests := []struct {
+		Input     string
+		Query     string
+		MapReduce bool
+	}{
+		{
+			`published:2014-06-01 AND keywords:(a NOT b)`,
+			`{"$or":[{"$and":[{"keywords":{"$not":{"Pattern":"^b$","Options":"i"}}}],"keywords":"a","pubdate":20140601}]}`,
+			false,
+		},
+		{
+			`published:2014-06-01 AND keywords:((a OR b) NOT (c OR d))`,
+			`{"$or":[{"$nor":[{"keywords":{"$in":[{"Pattern":"^c$","Options":"i"},{"Pattern":"^d$","Options":"i"}]}}],"keywords":"a","pubdate":20140601},{"$nor":[{"keywords":{"$in":[{"Pattern":"^c$","Options":"i"},{"Pattern":"^d$","Options":"i"}]}}],"keywords":"b","pubdate":20140601}]}`,
+			false,
+		},
+		{
+			`published:2014-06-01 AND keywords:(a OR (b NOT c))`,
+			`{"$or":[{"keywords":"a","pubdate":20140601},{"$and":[{"keywords":{"$not":{"Pattern":"^c$","Options":"i"}}}],"keywords":"b","pubdate":20140601}]}`,
+			false,
+		},
+		{
+			`published:2014-06-01 AND keywords:a NOT pubid:53678fb4800b8e4c9d0002c9`,
+			`{"$or":[{"keywords":"a","pubdate":20140601,"pubid":{"$ne":"53678fb4800b8e4c9d0002c9"}}]}`,
+			false,
+		},
+		{
+			`published:2014-06-01 AND keywords:a NOT pubid:(53678fb4800b8e4c9d0002c9 OR 53678ea54113de7739000214)`,
+			`{"$or":[{"$nor":[{"pubid":{"$in":["53678fb4800b8e4c9d0002c9","53678ea54113de7739000214"]}}],"keywords":"a","pubdate":20140601}]}`,
+			false,
+		},
+		{
+			`published:2014-06-01 AND keywords:a NOT published:2014-06-02`,
+			`{"$or":[{"$and":[{"pubdate":{"$ne":20140602}}],"keywords":"a","pubdate":20140601}]}`,
+			false,
+		},
+		{
+			`published:2014-06-01 AND (keywords:"a b" NOT pubid:53678fb4800b8e4c9d0002c9)`,
+			`{"$or":[{"keywords":{"$all":["a","b"]},"pubdate":20140601,"pubid":{"$ne":"53678fb4800b8e4c9d0002c9"}}]}`,
+			true,
+		},
+		{
+			`published:2014-06-01 AND keywords:(a NOT "b c")`,
+			`{"$or":[{"keywords":"a","pubdate":20140601}]}`,
+			true,
+		},
+		{
+			`published:2014-06-01 AND keywords:(a NOT (b OR "c d"))`,
+			`{"$or":[{"keywords":"a","pubdate":20140601}]}`,
+			true,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetAll("all")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetPubid("pubid", ConvertBsonId)
+
+		query, err := searchquery.ParseGreedy(test.Input)
+		if err != nil {
+			t.Fatalf("searchquery.ParseGreedy: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+		if ms.reqMapReduce != test.MapReduce {
+			t.Errorf("[%d] Expected reqMapReduce = %v", i, test.MapReduce)
+		}
+	}
+
+	// An exclusion on another field within an OR'd group cannot be applied
+	ms, _ := New("", "Items", "Results")
+	ms.SetKeyword("keywords", ConvertSpaces)
+	ms.SetPubdate("pubdate", ConvertDateInt, "published")
+	ms.SetPubid("pubid", ConvertBsonId)
+	query, _ := parseQuery(`published:2014-06-01 AND ((keywords:a NOT pubid:53678fb4800b8e4c9d0002c9) OR keywords:b)`)
+	if _, err := ms.buildQuery(query); err == nil {
+		t.Error("Expected an error excluding pubid within an OR group")
+	}
+}
+
+func TestBuildQueryExcludedCase(t *testing.T) {
+	tests := []struct {
+		CaseSensitive bool
+		Query         string
+	}{
+		{true, `{"$or":[{"$and":[{"keywords":{"$ne":"B"}}],"keywords":"a","pubdate":20140601}]}`},
+		{false, `{"$or":[{"$and":[{"keywords":{"$not":{"Pattern":"^B$","Options":"i"}}}],"keywords":"a","pubdate":20140601}]}`},
+	}
+	for _, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetCaseSensitive(test.CaseSensitive)
+
+		query, _ := parseQuery(`published:2014-06-01 AND keywords:a NOT keywords:B`)
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("Expect: %s", test.Query)
+			t.Errorf("Got:    %s", b)
+		}
+		if ms.reqMapReduce {
+			t.Error("Expected the exclusion to be left to the query")
+		}
+	}
+
+	// Without JavaScript, word pairs cannot exclude a longer phrase exactly
+	ms, _ := New("", "Items", "Results")
+	ms.SetKeyword("keywords", ConvertSpaces)
+	ms.SetPubdate("pubdate", ConvertDateInt, "published")
+	ms.SetShingles("shingles")
+	ms.SetJavaScript(false)
+	query, _ := parseQuery(`published:2014-06-01 AND keywords:(a NOT "big data center")`)
+	if _, err := ms.buildQuery(query); err == nil {
+		t.Error("Expected an error excluding a long phrase without JavaScript")
+	}
+}
+
+func TestBuildScopeExcluded(t *testing.T) {
+	tests := []struct {
+		Input string
+		Scope string
+		Err   bool
+	}{
+		{
+			`keywords:a NOT keywords:"b c"`,
+			`{"and":["a"],"nor":["b c"]}`,
+			false,
+		},
+		{
+			`keywords:"a b" NOT pubid:53678fb4800b8e4c9d0002c9`,
+			`{"and":["a b"]}`,
+			false,
+		},
+		{
+			`keywords:"a b" NOT (keywords:c AND pubid:53678fb4800b8e4c9d0002c9)`,
+			`{"and":["a b"]}`,
+			false,
+		},
+		{
+			`keywords:a NOT (keywords:"b c" AND pubid:53678fb4800b8e4c9d0002c9)`,
+			``,
+			true,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubid("pubid", ConvertBsonId)
+
+		query, err := searchquery.ParseGreedy(test.Input)
+		if err != nil {
+			t.Fatalf("searchquery.ParseGreedy: %s", err)
+		}
+		scope, err := ms.buildScope(query)
+		if test.Err {
+			if err == nil {
+				t.Errorf("[%d] Expected an error for %s", i, test.Input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%d] buildScope: %s", i, err)
+		}
+		if b, _ := json.Marshal(scope); string(b) != test.Scope {
+			t.Errorf("[%d] Expect: %s", i, test.Scope)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+	}
+}
+
+func TestBuildQueryDateRange(t *testing.T) {
+	tests := []struct {
+		Input string
+		Query string
+	}{
+		{
+			`published>=2014-06-01 AND published<=2014-06-30 AND keywords:(a OR b)`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":20140601,"$lte":20140630}},{"keywords":"b","pubdate":{"$gte":20140601,"$lte":20140630}}]}`,
+		},
+		{
+			`published:[2014-06-01 TO 2014-06-30] AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":20140601,"$lte":20140630}}]}`,
+		},
+		{
+			`published:['2014-06-01' TO *] AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":20140601}}]}`,
+		},
+		{
+			`published:(2014-06-01 OR 2014-06-02) AND keywords:(a OR b)`,
+			`{"$or":[{"keywords":"a","pubdate":{"$in":[20140601,20140602]}},{"keywords":"b","pubdate":{"$in":[20140601,20140602]}}]}`,
+		},
+		{
+			`published>=2014-06-01 AND published>=2014-06-02 AND keywords:a`,
+			`{"$or":[{"$and":[{"pubdate":{"$gte":20140602}}],"keywords":"a","pubdate":{"$gte":20140601}}]}`,
+		},
+		{
+			`(published:2014-06-01 OR published:2014-06-03) AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$in":[20140601,20140603]}}]}`,
+		},
+		{
+			`(published:[2014-06-01 TO 2014-06-02] OR published:2014-06-10) AND keywords:a`,
+			`{"$or":[{"$or":[{"$and":[{"pubdate":{"$gte":20140601}},{"pubdate":{"$lte":20140602}}]},{"pubdate":20140610}],"keywords":"a"}]}`,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetAll("all")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetPubid("pubid", ConvertBsonId)
+
+		query, err := parseQuery(test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+	}
+}
+
+func TestParseQueryPhraseRange(t *testing.T) {
+	query, err := parseQuery(`published:2014-06-01 AND keywords:"see x:[a TO b] here"`)
+	if err != nil {
+		t.Fatalf("parseQuery: %s", err)
+	}
+	sq := query.Required[1]
+	if expect := `see x:[a TO b] here`; sq.Value != expect {
+		t.Errorf("Expected phrase %q, got %q", expect, sq.Value)
+	}
+}
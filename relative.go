@@ -0,0 +1,133 @@
+package mongosearch
+
+import (
+	"fmt"
+	"github.com/300brand/searchquery"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// relativeOffset matches now+Nd style expressions; units are days, weeks,
+// months and years
+var relativeOffset = regexp.MustCompile(`^now([+-])(\d+)([dwmy])$`)
+
+// relativeRange resolves a relative date expression to the half-open range of
+// days [start, end) it covers. ok is false if expr is not relative.
+func relativeRange(expr string, now time.Time) (start, end time.Time, ok bool) {
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	// Weeks start on Monday
+	monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	month := time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	year := time.Date(y, 1, 1, 0, 0, 0, 0, now.Location())
+
+	switch expr = strings.ToLower(expr); expr {
+	case "now", "today":
+		return today, today.AddDate(0, 0, 1), true
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, true
+	case "this-week":
+		return monday, monday.AddDate(0, 0, 7), true
+	case "last-week":
+		return monday.AddDate(0, 0, -7), monday, true
+	case "this-month":
+		return month, month.AddDate(0, 1, 0), true
+	case "last-month":
+		return month.AddDate(0, -1, 0), month, true
+	case "this-year":
+		return year, year.AddDate(1, 0, 0), true
+	case "last-year":
+		return year.AddDate(-1, 0, 0), year, true
+	}
+
+	match := relativeOffset.FindStringSubmatch(expr)
+	if match == nil {
+		return
+	}
+	n, _ := strconv.Atoi(match[2])
+	if match[1] == "-" {
+		n = -n
+	}
+	switch match[3] {
+	case "d":
+		start = today.AddDate(0, 0, n)
+	case "w":
+		start = today.AddDate(0, 0, 7*n)
+	case "m":
+		start = today.AddDate(0, n, 0)
+	case "y":
+		start = today.AddDate(n, 0, 0)
+	}
+	return start, start.AddDate(0, 0, 1), true
+}
+
+// resolveDates replaces relative expressions on the pubdate field throughout
+// query with groups of absolute bounds, resolved against the clock
+func (s *MongoSearch) resolveDates(query *searchquery.Query) (err error) {
+	for _, subqueries := range [][]searchquery.SubQuery{query.Required, query.Optional, query.Excluded} {
+		for i := range subqueries {
+			sq := &subqueries[i]
+			if sq.Query != nil {
+				if err = s.resolveDates(sq.Query); err != nil {
+					return
+				}
+				continue
+			}
+
+			field := sq.Field
+			if newName, ok := s.Rewrites[field]; ok {
+				field = newName
+			}
+			if field != s.fields.pubdate {
+				continue
+			}
+			start, end, ok := relativeRange(sq.Value, s.now())
+			if !ok {
+				continue
+			}
+			if *sq, err = s.relativeSubquery(sq, start, end); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// relativeSubquery builds the group of bounds equivalent to applying the
+// operator of sq to the range [start, end)
+func (s *MongoSearch) relativeSubquery(sq *searchquery.SubQuery, start, end time.Time) (group searchquery.SubQuery, err error) {
+	bound := func(op searchquery.SubQuery, t time.Time) searchquery.SubQuery {
+		op.Field, op.Value = sq.Field, s.dateBound(t)
+		return op
+	}
+	gte := searchquery.SubQuery{Operator: searchquery.OperatorRelGTE}
+	lt := searchquery.SubQuery{Operator: searchquery.OperatorRelLT}
+
+	q := new(searchquery.Query)
+	switch sq.Operator {
+	case searchquery.OperatorField, searchquery.OperatorRelE:
+		q.Required = append(q.Required, bound(gte, start), bound(lt, end))
+	case searchquery.OperatorRelNE:
+		q.Optional = append(q.Optional, bound(lt, start), bound(gte, end))
+	case searchquery.OperatorRelGTE:
+		q.Required = append(q.Required, bound(gte, start))
+	case searchquery.OperatorRelGT:
+		q.Required = append(q.Required, bound(gte, end))
+	case searchquery.OperatorRelLTE:
+		q.Required = append(q.Required, bound(lt, end))
+	case searchquery.OperatorRelLT:
+		q.Required = append(q.Required, bound(lt, start))
+	default:
+		err = fmt.Errorf("Cannot use %s operator with relative date %s", sq.Operator, sq.Value)
+		return
+	}
+
+	group = searchquery.SubQuery{
+		Field:    sq.Field,
+		Operator: searchquery.OperatorSubquery,
+		Query:    q,
+	}
+	return
+}
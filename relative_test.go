@@ -0,0 +1,126 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelativeRange(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2014, 6, 25, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		Expr       string
+		Start, End string
+	}{
+		{"today", "2014-06-25", "2014-06-26"},
+		{"NOW", "2014-06-25", "2014-06-26"},
+		{"yesterday", "2014-06-24", "2014-06-25"},
+		{"now-7d", "2014-06-18", "2014-06-19"},
+		{"now+1d", "2014-06-26", "2014-06-27"},
+		{"now-2w", "2014-06-11", "2014-06-12"},
+		{"now-1m", "2014-05-25", "2014-05-26"},
+		{"now-1y", "2013-06-25", "2013-06-26"},
+		{"this-week", "2014-06-23", "2014-06-30"},
+		{"last-week", "2014-06-16", "2014-06-23"},
+		{"this-month", "2014-06-01", "2014-07-01"},
+		{"last-month", "2014-05-01", "2014-06-01"},
+		{"this-year", "2014-01-01", "2015-01-01"},
+		{"last-year", "2013-01-01", "2014-01-01"},
+	}
+
+	for _, test := range tests {
+		start, end, ok := relativeRange(test.Expr, now)
+		if !ok {
+			t.Errorf("%s: not recognized", test.Expr)
+			continue
+		}
+		if s, e := start.Format(TimeLayout), end.Format(TimeLayout); s != test.Start || e != test.End {
+			t.Errorf("%s: expected [%s, %s), got [%s, %s)", test.Expr, test.Start, test.End, s, e)
+		}
+	}
+
+	for _, expr := range []string{"2014-06-25", "now-7", "next-week", ""} {
+		if _, _, ok := relativeRange(expr, now); ok {
+			t.Errorf("%s: should not be relative", expr)
+		}
+	}
+
+	// Sunday belongs to the week started the Monday before
+	sunday := time.Date(2014, 6, 29, 0, 0, 0, 0, time.UTC)
+	if start, _, _ := relativeRange("this-week", sunday); start.Format(TimeLayout) != "2014-06-23" {
+		t.Errorf("this-week on a Sunday: expected 2014-06-23, got %s", start.Format(TimeLayout))
+	}
+}
+
+func TestBuildQueryRelative(t *testing.T) {
+	tests := []struct {
+		Input string
+		Query string
+	}{
+		{
+			`published:yesterday AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":20140624,"$lt":20140625}}]}`,
+		},
+		{
+			`published>=now-7d AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":20140618}}]}`,
+		},
+		{
+			`published>last-month AND published<=today AND keywords:a`,
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":20140601,"$lt":20140626}}]}`,
+		},
+		{
+			`published:this-month AND keywords:a NOT published:this-week`,
+			`{"$or":[{"$nor":[{"$and":[{"pubdate":{"$gte":20140623}},{"pubdate":{"$lt":20140630}}]}],"keywords":"a","pubdate":{"$gte":20140601,"$lt":20140701}}]}`,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetAll("all")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetPubid("pubid", ConvertBsonId)
+		ms.SetClock(func() time.Time {
+			return time.Date(2014, 6, 25, 15, 4, 5, 0, time.UTC)
+		})
+
+		query, err := parseQuery(test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+	}
+}
+
+func TestSearchRelativeParsed(t *testing.T) {
+	s, store := newMemorySearch(t)
+	s.SetClock(func() time.Time {
+		return time.Date(2014, 6, 2, 15, 4, 5, 0, time.UTC)
+	})
+	id, err := s.Search(`date:today AND keywords:a`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var meta struct {
+		Query struct {
+			Parsed string
+		}
+	}
+	if err := store.Meta(id, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(meta.Query.Parsed, "today") {
+		t.Errorf("Expected the query as typed, got %s", meta.Query.Parsed)
+	}
+}
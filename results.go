@@ -0,0 +1,160 @@
+package mongosearch
+
+import (
+	"labix.org/v2/mgo/bson"
+)
+
+// Number of item ids looked up at a time when hydrating results
+const hydrateBatch = 100
+
+// ResultOptions select and order the results returned by Results
+type ResultOptions struct {
+	Skip    int  // Number of results to skip
+	Limit   int  // Maximum number of results; zero for no limit
+	Sort    int  // 1 for oldest pubdate first, -1 for newest first, 0 unsorted
+	Hydrate bool // Return full documents from CollItems instead of only ids
+}
+
+// ResultIter walks over the results of a search. It may hold a socket from
+// the pool until closed.
+type ResultIter struct {
+	store    Store
+	id       bson.ObjectId
+	iter     Iter
+	hydrate  bool
+	buffered []bson.Raw
+	err      error
+}
+
+// rawId is used to compare _id values of any type
+type rawId struct {
+	Id bson.Raw `bson:"_id"`
+}
+
+func (r rawId) key() string {
+	return string(r.Id.Kind) + string(r.Id.Data)
+}
+
+// Results opens the results of search id. Each call to Next decodes a
+// document containing the _id of a matching item, or the entire item when
+// opts.Hydrate is set.
+func (s *MongoSearch) Results(id bson.ObjectId, opts ResultOptions) (it *ResultIter, err error) {
+	iter, err := s.store.Results(id, opts)
+	if err != nil {
+		return
+	}
+	it = &ResultIter{
+		store:   s.store,
+		id:      id,
+		iter:    iter,
+		hydrate: opts.Hydrate,
+	}
+	return
+}
+
+// sourceIter is implemented by store iterators which count and load results
+// themselves, reusing the connection they hold
+type sourceIter interface {
+	Count() (int, error)
+	Items(ids []interface{}) ([]bson.Raw, error)
+}
+
+// Count returns the total number of results, regardless of Skip and Limit
+func (it *ResultIter) Count() (n int, err error) {
+	if src, ok := it.iter.(sourceIter); ok {
+		return src.Count()
+	}
+	return it.store.ResultCount(it.id)
+}
+
+// items loads the items with the given ids
+func (it *ResultIter) items(ids []interface{}) ([]bson.Raw, error) {
+	if src, ok := it.iter.(sourceIter); ok {
+		return src.Items(ids)
+	}
+	return it.store.Items(ids)
+}
+
+// Next decodes the next result into result, returning false once the results
+// are exhausted or an error occurs. Check Err or Close afterwards.
+func (it *ResultIter) Next(result interface{}) bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.hydrate {
+		return it.iter.Next(result)
+	}
+
+	if len(it.buffered) == 0 {
+		if it.err = it.fill(); it.err != nil || len(it.buffered) == 0 {
+			return false
+		}
+	}
+
+	raw := it.buffered[0]
+	it.buffered = it.buffered[1:]
+	if it.err = raw.Unmarshal(result); it.err != nil {
+		return false
+	}
+	return true
+}
+
+// fill loads the items for the next batch of result ids, keeping the order of
+// the results. Items removed since the search ran are skipped.
+func (it *ResultIter) fill() (err error) {
+	ids := make([]interface{}, 0, hydrateBatch)
+	keys := make([]string, 0, hydrateBatch)
+	var id rawId
+	for len(ids) < hydrateBatch && it.iter.Next(&id) {
+		var value interface{}
+		if err = id.Id.Unmarshal(&value); err != nil {
+			return
+		}
+		ids = append(ids, value)
+		keys = append(keys, id.key())
+	}
+	if err = it.iter.Err(); err != nil || len(ids) == 0 {
+		return
+	}
+
+	items, err := it.items(ids)
+	if err != nil {
+		return
+	}
+
+	byKey := make(map[string]bson.Raw, len(items))
+	for _, item := range items {
+		if err = item.Unmarshal(&id); err != nil {
+			return
+		}
+		byKey[id.key()] = item
+	}
+	for _, key := range keys {
+		if item, ok := byKey[key]; ok {
+			it.buffered = append(it.buffered, item)
+		}
+	}
+
+	// Every item in this batch may be gone; move on to the next
+	if len(it.buffered) == 0 {
+		return it.fill()
+	}
+	return
+}
+
+// Err returns the first error encountered by Next
+func (it *ResultIter) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.iter.Err()
+}
+
+// Close releases the iterator and returns any error encountered
+func (it *ResultIter) Close() (err error) {
+	err = it.iter.Close()
+	if it.err != nil {
+		err = it.err
+	}
+	return
+}
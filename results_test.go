@@ -0,0 +1,58 @@
+package mongosearch
+
+import (
+	"testing"
+)
+
+func TestResults(t *testing.T) {
+	if *ServerAddr == "" {
+		t.Skip("No mongo server provided")
+	}
+
+	resetDB(t)
+
+	s, err := New(*ServerAddr, "Items", "Results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetAll("all")
+	s.SetKeyword("keywords", ConvertSpaces)
+	s.SetPubdate("date", ConvertDate)
+	s.SetPubid("pubid", ConvertBsonId)
+
+	id, err := s.Search("date:2014-06-02 AND a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The open iterator's session must serve counting and hydrating
+	s.SetPoolLimit(1)
+	it, err := s.Results(id, ResultOptions{Sort: 1, Limit: 1, Hydrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	if n, err := it.Count(); err != nil || n != 2 {
+		t.Errorf("Expected 2 results, got %d (%v)", n, err)
+	}
+
+	var docs []struct {
+		Id  int
+		All []string
+	}
+	var doc struct {
+		Id  int
+		All []string
+	}
+	for it.Next(&doc) {
+		docs = append(docs, doc)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || len(docs[0].All) == 0 {
+		t.Errorf("Expected one hydrated document, got %+v", docs)
+	}
+}
@@ -0,0 +1,28 @@
+package mongosearch
+
+// Shingles returns every pair of adjacent words, joined by a space. Store the
+// result of calling it on an item's all-words array in the field given to
+// SetShingles.
+func Shingles(words []string) (shingles []string) {
+	if len(words) < 2 {
+		return
+	}
+	shingles = make([]string, 0, len(words)-1)
+	for i := 1; i < len(words); i++ {
+		shingles = append(shingles, words[i-1]+" "+words[i])
+	}
+	return
+}
+
+// shingle converts a phrase on the keyword field into the shingles an item
+// must contain. Two-word phrases are matched exactly; longer phrases only
+// ensure every pair is present somewhere, so the map function must still check
+// them. Like the keyword field, shingles are compared case-sensitively.
+// Returns nil if phrases are not matched with shingles.
+func (s *MongoSearch) shingle(field string, value interface{}) (shingles []string, exact bool) {
+	phrase, ok := value.([]string)
+	if !ok || field != s.fields.keyword || s.fields.shingle == "" {
+		return
+	}
+	return Shingles(phrase), len(phrase) <= 2
+}
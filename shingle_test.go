@@ -0,0 +1,113 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"github.com/300brand/searchquery"
+	"reflect"
+	"testing"
+)
+
+func TestShingles(t *testing.T) {
+	tests := []struct {
+		In  []string
+		Out []string
+	}{
+		{nil, nil},
+		{[]string{"a"}, nil},
+		{[]string{"a", "b"}, []string{"a b"}},
+		{[]string{"a", "b", "c"}, []string{"a b", "b c"}},
+	}
+	for _, test := range tests {
+		if out := Shingles(test.In); !reflect.DeepEqual(out, test.Out) {
+			t.Errorf("%q: expected %q, got %q", test.In, test.Out, out)
+		}
+	}
+}
+
+func TestBuildQueryShingles(t *testing.T) {
+	tests := []struct {
+		Input      string
+		Query      string
+		MapReduce  bool
+		JavaScript bool
+	}{
+		{
+			`published:2014-06-01 AND keywords:"data center"`,
+			`{"$or":[{"pubdate":20140601,"shingles":"data center"}]}`,
+			false,
+			true,
+		},
+		{
+			`published:2014-06-01 AND keywords:"big data center"`,
+			`{"$or":[{"pubdate":20140601,"shingles":{"$all":["big data","data center"]}}]}`,
+			true,
+			true,
+		},
+		{
+			`published:2014-06-01 AND keywords:"data center"`,
+			`{"$or":[{"pubdate":20140601,"shingles":"data center"}]}`,
+			false,
+			false,
+		},
+		{
+			`published:2014-06-01 AND keywords:(a NOT "data center")`,
+			`{"$or":[{"$nor":[{"shingles":{"Pattern":"^data center$","Options":"i"}}],"keywords":"a","pubdate":20140601}]}`,
+			false,
+			true,
+		},
+		{
+			`published:2014-06-01 AND keywords:(cloud NOT "google NEAR/1 data")`,
+			`{"$or":[{"keywords":"cloud","pubdate":20140601}]}`,
+			true,
+			true,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetAll("all")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetPubid("pubid", ConvertBsonId)
+		ms.SetShingles("shingles")
+		ms.SetJavaScript(test.JavaScript)
+
+		query, err := searchquery.ParseGreedy(test.Input)
+		if err != nil {
+			t.Fatalf("searchquery.ParseGreedy: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+		if ms.reqMapReduce != test.MapReduce {
+			t.Errorf("[%d] Expected reqMapReduce = %v", i, test.MapReduce)
+		}
+	}
+}
+
+func TestBuildQueryShinglesNoJavaScript(t *testing.T) {
+	ms, _ := New("", "Items", "Results")
+	ms.SetKeyword("keywords", ConvertSpaces)
+	ms.SetPubdate("pubdate", ConvertDateInt, "published")
+	ms.SetShingles("shingles")
+	ms.SetJavaScript(false)
+
+	// Word pairs would also match items holding them apart
+	query, err := searchquery.ParseGreedy(`published:2014-06-01 AND keywords:"big data center"`)
+	if err != nil {
+		t.Fatalf("searchquery.ParseGreedy: %s", err)
+	}
+	if _, err := ms.buildQuery(query); err == nil {
+		t.Error("Expected an error for a long phrase without JavaScript")
+	}
+	// Nor can they exclude words near each other
+	query, _ = searchquery.ParseGreedy(`published:2014-06-01 AND keywords:(cloud NOT "google NEAR/1 data")`)
+	if _, err := ms.buildQuery(query); err == nil {
+		t.Error("Expected an error excluding NEAR without JavaScript")
+	}
+}
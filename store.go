@@ -0,0 +1,72 @@
+package mongosearch
+
+import (
+	"context"
+	"labix.org/v2/mgo/bson"
+)
+
+// Store runs searches and keeps their metadata and results. The default store
+// talks to MongoDB through mgo; MemoryStore keeps everything in memory.
+type Store interface {
+	// Execute writes the items matching job into the results for job.Id and
+	// returns statistics to record in the metadata document. When ctx is
+	// done, work is abandoned and ctx's error returned.
+	Execute(ctx context.Context, job *Job) (info interface{}, err error)
+
+	// SetMeta merges set into the metadata document for search id, creating
+	// it if needed. Keys may use dot notation.
+	SetMeta(id bson.ObjectId, set bson.M) error
+
+	// Meta decodes the metadata document for search id into result
+	Meta(id bson.ObjectId, result interface{}) error
+
+	// Searches decodes the metadata documents matching filter into the slice
+	// pointed to by result, newest first, after skipping skip of them
+	Searches(filter bson.M, skip int, result interface{}) error
+
+	// Results iterates over documents holding the _id of each result of
+	// search id, in the order and range given by opts. Hydrate is ignored.
+	Results(id bson.ObjectId, opts ResultOptions) (Iter, error)
+
+	// ResultCount returns the total number of results of search id
+	ResultCount(id bson.ObjectId) (int, error)
+
+	// Items returns the items with the given ids, in no particular order
+	Items(ids []interface{}) ([]bson.Raw, error)
+
+	// Drop removes the results of search id
+	Drop(id bson.ObjectId) error
+
+	// Close releases any resources held by the store
+	Close()
+}
+
+// Iter walks over a set of documents; *mgo.Iter satisfies it
+type Iter interface {
+	Next(result interface{}) bool
+	Err() error
+	Close() error
+}
+
+// Job is a search ready to be run by a Store
+type Job struct {
+	Id            bson.ObjectId
+	Query         bson.M // Filter for the items, from buildQuery
+	Scope         bson.M // Phrase and exclusion tree, from buildScope
+	MapReduce     bool   // Items must also satisfy Scope
+	Backend       Backend
+	CaseSensitive bool
+	Normalization Normalization // Applied to the all-words array before matching Scope
+	All           string        // Field holding the all-words array
+	Pubdate       string        // Field holding the publish date, kept for sorting results
+}
+
+// NewWithStore creates a MongoSearch which runs searches through store rather
+// than dialing MongoDB
+func NewWithStore(store Store) (s *MongoSearch, err error) {
+	if s, err = New("", "", ""); err != nil {
+		return
+	}
+	s.store = store
+	return
+}
@@ -0,0 +1,220 @@
+package mongosearch
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/300brand/searchquery"
+	"io"
+	"os"
+	"strings"
+)
+
+// SynonymsField is a pseudo-field controlling synonym expansion for a single
+// query: synonyms:off leaves the query's terms as they are
+const SynonymsField = "synonyms"
+
+// Expansion records a keyword term replaced by a group of synonyms
+type Expansion struct {
+	Term     string   `bson:"term"`
+	Synonyms []string `bson:"synonyms"` // Terms searched for, Term first
+}
+
+// SetSynonyms sets the groups of interchangeable keyword terms. A term in a
+// query matching any term of a group, once normalized and analyzed, is
+// expanded into an OR of the whole group. Terms may be phrases.
+func (s *MongoSearch) SetSynonyms(groups [][]string) {
+	s.synonyms = groups
+}
+
+// LoadSynonymsFile sets the synonym groups read from the file at path; see
+// ReadSynonyms for the format
+func (s *MongoSearch) LoadSynonymsFile(path string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	groups, err := ReadSynonyms(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	s.SetSynonyms(groups)
+	return
+}
+
+// LoadSynonymsCollection sets the synonym groups held in the terms array of
+// each document in collection, given as <db>.<coll> or <coll>
+func (s *MongoSearch) LoadSynonymsCollection(collection string) (err error) {
+	session, err := s.copySession(context.Background())
+	if err != nil {
+		return
+	}
+	defer s.releaseSession(session)
+
+	db, coll := s.dbFor(session, collection)
+	groups, err := readSynonymDocs(session.DB(db).C(coll).Find(nil).Iter())
+	if err != nil {
+		return
+	}
+	s.SetSynonyms(groups)
+	return
+}
+
+// ReadSynonyms reads one group of synonyms per line, with terms separated by
+// commas:
+//
+//	# Vendors
+//	HP, Hewlett-Packard, Hewlett Packard
+//
+// Blank lines and lines starting with # are skipped.
+func ReadSynonyms(r io.Reader) (groups [][]string, err error) {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var group []string
+		for _, term := range strings.Split(line, ",") {
+			if term = strings.TrimSpace(term); term != "" {
+				group = append(group, term)
+			}
+		}
+		if len(group) < 2 {
+			return nil, fmt.Errorf("Line %d: synonym groups need at least two terms", n)
+		}
+		groups = append(groups, group)
+	}
+	return groups, scanner.Err()
+}
+
+// readSynonymDocs collects the terms of each document from it, closing it
+func readSynonymDocs(it Iter) (groups [][]string, err error) {
+	var doc struct {
+		Terms []string `bson:"terms"`
+	}
+	for it.Next(&doc) {
+		if len(doc.Terms) > 1 {
+			groups = append(groups, doc.Terms)
+		}
+		doc.Terms = nil
+	}
+	if err = it.Close(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// synonymKey is the form in which terms are compared with synonyms
+func (s *MongoSearch) synonymKey(term string) string {
+	return strings.ToLower(strings.Join(s.analyze(term), " "))
+}
+
+// expandSynonyms replaces keyword terms having synonyms with an OR group of
+// them, unless the query opts out through SynonymsField, which is removed
+func (s *MongoSearch) expandSynonyms(query *searchquery.Query) (expansions []Expansion, err error) {
+	enabled, err := synonymsEnabled(query)
+	if err != nil || !enabled || len(s.synonyms) == 0 {
+		return
+	}
+
+	index := make(map[string][]int)
+	for i, group := range s.synonyms {
+		for _, term := range group {
+			key := s.synonymKey(term)
+			index[key] = append(index[key], i)
+		}
+	}
+	return s.expandTerms(query, index)
+}
+
+// synonymsEnabled removes SynonymsField from query and its groups, at any
+// depth, reporting whether any of them turned synonyms off
+func synonymsEnabled(query *searchquery.Query) (enabled bool, err error) {
+	enabled = true
+	for _, subqueries := range []*[]searchquery.SubQuery{&query.Required, &query.Optional} {
+		kept := (*subqueries)[:0]
+		for _, sq := range *subqueries {
+			if sq.Query != nil {
+				on, err := synonymsEnabled(sq.Query)
+				if err != nil {
+					return false, err
+				}
+				enabled = enabled && on
+				kept = append(kept, sq)
+				continue
+			}
+			if sq.Field != SynonymsField {
+				kept = append(kept, sq)
+				continue
+			}
+			switch strings.ToLower(sq.Value) {
+			case "on", "true", "yes":
+			case "off", "false", "no":
+				enabled = false
+			default:
+				return false, fmt.Errorf("Invalid value for %s: %s", SynonymsField, sq.Value)
+			}
+		}
+		*subqueries = kept
+	}
+	return
+}
+
+func (s *MongoSearch) expandTerms(query *searchquery.Query, index map[string][]int) (expansions []Expansion, err error) {
+	for _, subqueries := range [][]searchquery.SubQuery{query.Required, query.Optional, query.Excluded} {
+		for i := range subqueries {
+			sq := &subqueries[i]
+			if sq.Query != nil {
+				sub, err := s.expandTerms(sq.Query, index)
+				if err != nil {
+					return nil, err
+				}
+				expansions = append(expansions, sub...)
+				continue
+			}
+
+			field := sq.Field
+			if newName, ok := s.Rewrites[field]; ok {
+				field = newName
+			}
+			if field != s.fields.keyword || isPattern(sq.Value) || isNear(sq.Value) {
+				continue
+			}
+			if sq.Operator != searchquery.OperatorField && sq.Operator != searchquery.OperatorRelE {
+				continue
+			}
+			groups, ok := index[s.synonymKey(sq.Value)]
+			if !ok {
+				continue
+			}
+
+			expansion := Expansion{Term: sq.Value, Synonyms: []string{sq.Value}}
+			seen := map[string]bool{s.synonymKey(sq.Value): true}
+			for _, g := range groups {
+				for _, term := range s.synonyms[g] {
+					if key := s.synonymKey(term); !seen[key] {
+						seen[key] = true
+						expansion.Synonyms = append(expansion.Synonyms, term)
+					}
+				}
+			}
+
+			q := new(searchquery.Query)
+			for _, term := range expansion.Synonyms {
+				leaf := *sq
+				leaf.Value = term
+				q.Optional = append(q.Optional, leaf)
+			}
+			*sq = searchquery.SubQuery{
+				Field:    sq.Field,
+				Operator: searchquery.OperatorSubquery,
+				Query:    q,
+			}
+			expansions = append(expansions, expansion)
+		}
+	}
+	return
+}
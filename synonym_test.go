@@ -0,0 +1,186 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var vendorSynonyms = [][]string{
+	{"HP", "Hewlett-Packard", "Hewlett Packard"},
+	{"IBM", "International Business Machines"},
+}
+
+func TestReadSynonyms(t *testing.T) {
+	groups, err := ReadSynonyms(strings.NewReader(`
+# Vendors
+HP, Hewlett-Packard,Hewlett Packard
+
+  IBM ,International Business Machines,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groups, vendorSynonyms) {
+		t.Errorf("Expected %q, got %q", vendorSynonyms, groups)
+	}
+
+	if _, err := ReadSynonyms(strings.NewReader("HP, Hewlett-Packard\nIBM\n")); err == nil || !strings.Contains(err.Error(), "Line 2") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
+
+func TestLoadSynonymsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "synonyms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("HP, Hewlett-Packard, Hewlett Packard\nIBM, International Business Machines\n")
+	f.Close()
+
+	ms, _ := New("", "Items", "Results")
+	if err := ms.LoadSynonymsFile(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ms.synonyms, vendorSynonyms) {
+		t.Errorf("Expected %q, got %q", vendorSynonyms, ms.synonyms)
+	}
+	if err := ms.LoadSynonymsFile(f.Name() + ".missing"); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestBuildQuerySynonyms(t *testing.T) {
+	newSearch := func() *MongoSearch {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetNormalization(NormalizePunctuation)
+		ms.SetAnalyzer(NewAnalyzer(nil, LowercaseFilter))
+		ms.SetSynonyms(vendorSynonyms)
+		return ms
+	}
+	build := func(ms *MongoSearch, input string) (query, scope string) {
+		q, err := parseQuery(input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		built, err := ms.buildQuery(q)
+		if err != nil {
+			t.Fatalf("%s: %s", input, err)
+		}
+		builtScope, err := ms.buildScope(q)
+		if err != nil {
+			t.Fatalf("%s: %s", input, err)
+		}
+		b, _ := json.Marshal(built)
+		c, _ := json.Marshal(builtScope)
+		return string(b), string(c)
+	}
+
+	// Hewlett-Packard and Hewlett Packard are the same once normalized
+	tests := []struct {
+		Input      string
+		Equivalent string
+		Expansions []Expansion
+	}{
+		{
+			`published:2014-06-01 AND keywords:hp`,
+			`published:2014-06-01 AND keywords:(hp OR "Hewlett-Packard")`,
+			[]Expansion{{"hp", []string{"hp", "Hewlett-Packard"}}},
+		},
+		{
+			`published:2014-06-01 AND keywords:("hewlett packard" OR ibm)`,
+			`published:2014-06-01 AND keywords:(("hewlett packard" OR HP) OR (ibm OR "International Business Machines"))`,
+			[]Expansion{
+				{"hewlett packard", []string{"hewlett packard", "HP"}},
+				{"ibm", []string{"ibm", "International Business Machines"}},
+			},
+		},
+		{
+			`published:2014-06-01 AND keywords:(cloud NOT HP)`,
+			`published:2014-06-01 AND keywords:(cloud NOT (HP OR "Hewlett-Packard"))`,
+			[]Expansion{{"HP", []string{"HP", "Hewlett-Packard"}}},
+		},
+		{
+			`published:2014-06-01 AND keywords:(hp OR cloud) AND synonyms:off`,
+			`published:2014-06-01 AND keywords:(hp OR cloud)`,
+			nil,
+		},
+		{
+			`published:2014-06-01 AND (keywords:hp AND synonyms:off)`,
+			`published:2014-06-01 AND (keywords:hp)`,
+			nil,
+		},
+		{
+			`published:2014-06-01 AND keywords:"hp NEAR/2 cloud"`,
+			`published:2014-06-01 AND keywords:"hp NEAR/2 cloud"`,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		ms := newSearch()
+		query, scope := build(ms, test.Input)
+		if !reflect.DeepEqual(ms.expansions, test.Expansions) {
+			t.Errorf("%s: expected expansions %+v, got %+v", test.Input, test.Expansions, ms.expansions)
+		}
+
+		plain := newSearch()
+		plain.SetSynonyms(nil)
+		expectQuery, expectScope := build(plain, test.Equivalent)
+		if query != expectQuery || scope != expectScope {
+			t.Errorf("Input:  %s", test.Input)
+			t.Errorf("Expect: %s %s", expectQuery, expectScope)
+			t.Errorf("Got:    %s %s", query, scope)
+		}
+	}
+
+	ms := newSearch()
+	q, _ := parseQuery(`published:2014-06-01 AND keywords:hp AND synonyms:maybe`)
+	if _, err := ms.buildQuery(q); err == nil {
+		t.Error("Expected an error for an invalid synonyms setting")
+	}
+}
+
+func TestMemoryStoreSynonyms(t *testing.T) {
+	s, store := newMemorySearch(t)
+	if err := store.Insert(
+		memDoc{Id: 6, PubId: pubs[0], Date: time.Date(2014, 6, 4, 0, 0, 0, 0, time.UTC), All: []string{"Hewlett", "Packard", "a"}, Kws: []string{"Hewlett", "Packard", "a"}},
+		memDoc{Id: 7, PubId: pubs[1], Date: time.Date(2014, 6, 4, 0, 0, 0, 0, time.UTC), All: []string{"HP", "b"}, Kws: []string{"HP", "b"}},
+		memDoc{Id: 8, PubId: pubs[2], Date: time.Date(2014, 6, 4, 0, 0, 0, 0, time.UTC), All: []string{"Packard", "Hewlett"}, Kws: []string{"Packard", "Hewlett"}},
+	); err != nil {
+		t.Fatal(err)
+	}
+	s.SetSynonyms(vendorSynonyms)
+
+	tests := []struct {
+		Query    string
+		Ids      []int
+		Synonyms []Expansion
+	}{
+		{`date:2014-06-04 AND keywords:HP`, []int{6, 7}, []Expansion{{"HP", []string{"HP", "Hewlett-Packard", "Hewlett Packard"}}}},
+		{`date:2014-06-04 AND keywords:HP AND synonyms:off`, []int{7}, nil},
+		{`date:2014-06-04 AND keywords:(a NOT HP)`, []int{5}, []Expansion{{"HP", []string{"HP", "Hewlett-Packard", "Hewlett Packard"}}}},
+	}
+	for _, test := range tests {
+		id, err := s.Search(test.Query)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Query, err)
+		}
+		if ids := memResults(t, s, id, ResultOptions{}); !reflect.DeepEqual(ids, test.Ids) {
+			t.Errorf("%s: expected %v, got %v", test.Query, test.Ids, ids)
+		}
+		status, err := s.Status(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(status.Synonyms)+len(test.Synonyms) > 0 && !reflect.DeepEqual(status.Synonyms, test.Synonyms) {
+			t.Errorf("%s: expected synonyms %+v, got %+v", test.Query, test.Synonyms, status.Synonyms)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package mongosearch
+
+import (
+	"fmt"
+	"github.com/300brand/searchquery"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strings"
+)
+
+// Wildcards limits the cost of wildcard and regular expression terms. Zero
+// values disable the corresponding limit.
+type Wildcards struct {
+	MinPrefix int // Literal characters required before the first wildcard
+	MaxTerms  int // Wildcard and regular expression terms allowed per query
+}
+
+// SetWildcards sets the limits on wildcard and regular expression terms
+func (s *MongoSearch) SetWildcards(w Wildcards) {
+	s.wildcards = w
+}
+
+// isPattern reports whether term is a wildcard or regular expression term
+func isPattern(term string) bool {
+	return strings.ContainsAny(term, "*?") || isRegexTerm(term)
+}
+
+// isRegexTerm reports whether term is written as /regex/
+func isRegexTerm(term string) bool {
+	return len(term) > 2 && term[0] == '/' && term[len(term)-1] == '/'
+}
+
+// termPattern returns the anchored regular expression matching the same words
+// as term, along with the literal prefix every match starts with. Terms ending
+// in their only wildcard, *, become a plain prefix match so an index can be
+// used.
+func termPattern(term string) (pattern, prefix string, err error) {
+	if isRegexTerm(term) {
+		expr := term[1 : len(term)-1]
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid regular expression %s: %s", term, err)
+		}
+		prefix, _ = re.LiteralPrefix()
+		return "^(?:" + expr + ")$", prefix, nil
+	}
+
+	i := strings.IndexAny(term, "*?")
+	prefix = term[:i]
+	if i == len(term)-1 && term[i] == '*' {
+		return "^" + regexp.QuoteMeta(prefix), prefix, nil
+	}
+
+	pattern = "^"
+	for _, r := range term {
+		switch r {
+		case '*':
+			pattern += ".*"
+		case '?':
+			pattern += "."
+		default:
+			pattern += regexp.QuoteMeta(string(r))
+		}
+	}
+	return pattern + "$", prefix, nil
+}
+
+// patternValue turns converted keyword values holding wildcard or regular
+// expression terms into patterns. Phrases keep their plain words, with
+// bson.RegEx in place of the others.
+func patternValue(out Converted) (Converted, error) {
+	switch out.Kind {
+	case ValueScalar:
+		term, ok := out.Value.(string)
+		if !ok || !isPattern(term) {
+			return out, nil
+		}
+		pattern, _, err := termPattern(term)
+		if err != nil {
+			return out, err
+		}
+		return Converted{Kind: ValuePattern, Value: pattern}, nil
+	case ValueAll:
+		words, ok := out.Value.([]string)
+		if !ok {
+			return out, nil
+		}
+		var values []interface{}
+		for i, word := range words {
+			if !isPattern(word) {
+				continue
+			}
+			if values == nil {
+				values = make([]interface{}, len(words))
+				for j := range words {
+					values[j] = words[j]
+				}
+			}
+			pattern, _, err := termPattern(word)
+			if err != nil {
+				return out, err
+			}
+			values[i] = bson.RegEx{Pattern: pattern}
+		}
+		if values != nil {
+			out.Value = values
+		}
+	}
+	return out, nil
+}
+
+// checkWildcards enforces the wildcard limits on the keyword terms of query
+func (s *MongoSearch) checkWildcards(query *searchquery.Query) error {
+	n := 0
+	var walk func(q *searchquery.Query) error
+	walk = func(q *searchquery.Query) error {
+		for _, subqueries := range [][]searchquery.SubQuery{q.Required, q.Optional, q.Excluded} {
+			for _, sq := range subqueries {
+				if sq.Query != nil {
+					if err := walk(sq.Query); err != nil {
+						return err
+					}
+					continue
+				}
+				field := sq.Field
+				if newName, ok := s.Rewrites[field]; ok {
+					field = newName
+				}
+				if field != s.fields.keyword {
+					continue
+				}
+				for _, word := range strings.Fields(sq.Value) {
+					if !isPattern(word) {
+						continue
+					}
+					_, prefix, err := termPattern(word)
+					if err != nil {
+						return err
+					}
+					if min := s.wildcards.MinPrefix; min > 0 && len(prefix) < min {
+						return fmt.Errorf("Wildcard term %s needs at least %d leading characters", word, min)
+					}
+					if n++; s.wildcards.MaxTerms > 0 && n > s.wildcards.MaxTerms {
+						return fmt.Errorf("Query has more than %d wildcard terms", s.wildcards.MaxTerms)
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return walk(query)
+}
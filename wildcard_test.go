@@ -0,0 +1,152 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTermPattern(t *testing.T) {
+	tests := []struct {
+		Term, Pattern, Prefix string
+	}{
+		{"secur*", "^secur", "secur"},
+		{"a.b*", `^a\.b`, "a.b"},
+		{"cyber?security", "^cyber.security$", "cyber"},
+		{"*ware", "^.*ware$", ""},
+		{"se*ty", "^se.*ty$", "se"},
+		{"/cyber.?security/", "^(?:cyber.?security)$", "cyber"},
+		{"/[a-z]+/", "^(?:[a-z]+)$", ""},
+	}
+	for _, test := range tests {
+		if !isPattern(test.Term) {
+			t.Errorf("%s: not a pattern", test.Term)
+			continue
+		}
+		pattern, prefix, err := termPattern(test.Term)
+		if err != nil {
+			t.Errorf("%s: %s", test.Term, err)
+			continue
+		}
+		if pattern != test.Pattern || prefix != test.Prefix {
+			t.Errorf("%s: expected %q (%q), got %q (%q)", test.Term, test.Pattern, test.Prefix, pattern, prefix)
+		}
+	}
+
+	for _, term := range []string{"security", "/", "//", "a/b"} {
+		if isPattern(term) {
+			t.Errorf("%s: should not be a pattern", term)
+		}
+	}
+	if _, _, err := termPattern("/(/"); err == nil {
+		t.Error("Expected an error for an invalid regular expression")
+	}
+}
+
+func TestBuildQueryWildcards(t *testing.T) {
+	tests := []struct {
+		Input     string
+		Query     string
+		MapReduce bool
+	}{
+		{
+			`published:2014-06-01 AND keywords:secur*`,
+			`{"$or":[{"keywords":{"$regex":"^secur"},"pubdate":20140601}]}`,
+			false,
+		},
+		{
+			`published:2014-06-01 AND keywords:(cloud OR /cyber.?security/)`,
+			`{"$or":[{"keywords":"cloud","pubdate":20140601},{"keywords":{"$regex":"^(?:cyber.?security)$"},"pubdate":20140601}]}`,
+			false,
+		},
+		{
+			`published:2014-06-01 AND keywords:(a NOT secur*)`,
+			`{"$or":[{"$nor":[{"keywords":{"$options":"i","$regex":"^secur"}}],"keywords":"a","pubdate":20140601}]}`,
+			false,
+		},
+		{
+			`published:2014-06-01 AND keywords:"data cent*"`,
+			`{"$or":[{"keywords":{"$all":["data",{"Pattern":"^cent","Options":""}]},"pubdate":20140601}]}`,
+			true,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetShingles("shingles")
+
+		query, err := parseQuery(test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+		if ms.reqMapReduce != test.MapReduce {
+			t.Errorf("[%d] Expected reqMapReduce = %v", i, test.MapReduce)
+		}
+	}
+}
+
+func TestWildcardLimits(t *testing.T) {
+	tests := []struct {
+		Limits Wildcards
+		Input  string
+		Err    bool
+	}{
+		{Wildcards{}, `keywords:*ware`, false},
+		{Wildcards{MinPrefix: 3}, `keywords:*ware`, true},
+		{Wildcards{MinPrefix: 3}, `keywords:se*`, true},
+		{Wildcards{MinPrefix: 3}, `keywords:sec*`, false},
+		{Wildcards{MinPrefix: 3}, `keywords:"big se*"`, true},
+		{Wildcards{MaxTerms: 2}, `keywords:(a* OR b* OR c)`, false},
+		{Wildcards{MaxTerms: 2}, `keywords:(a* OR b* NOT c*)`, true},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetWildcards(test.Limits)
+
+		query, err := parseQuery(`published:2014-06-01 AND ` + test.Input)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		if _, err := ms.buildQuery(query); (err != nil) != test.Err {
+			t.Errorf("[%d] %s: expected error %v, got %v", i, test.Input, test.Err, err)
+		}
+	}
+}
+
+func TestEvalScopeWildcards(t *testing.T) {
+	all := []string{"The", "data", "center", "and", "the", "cybersecurity", "team", "x"}
+	tests := []struct {
+		Phrase string
+		Match  bool
+	}{
+		{"data cent*", true},
+		{"data c?nter", true},
+		{"data c?ter", false},
+		{"da* center", true},
+		{"/cyber.?security/ team", true},
+		{"/cyber.?security/ room", false},
+		{"*security", true},
+	}
+	for _, test := range tests {
+		scope := map[string]interface{}{"and": []interface{}{test.Phrase}}
+		match, err := EvalScope(scope, all, false)
+		if err != nil {
+			t.Fatalf("%s: %s", test.Phrase, err)
+		}
+		if match != test.Match {
+			t.Errorf("%s: expected %v, got %v", test.Phrase, test.Match, match)
+		}
+	}
+}
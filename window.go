@@ -0,0 +1,128 @@
+package mongosearch
+
+import (
+	"fmt"
+	"github.com/300brand/searchquery"
+	"time"
+)
+
+// WindowMode selects how a DateWindow fills in queries without a pubdate
+type WindowMode int
+
+const (
+	WindowToday    WindowMode = iota // Only today's items
+	WindowNone                       // No restriction on pubdate
+	WindowDays                       // The last Days days, today included
+	WindowRange                      // From through To
+	WindowRequired                   // Reject the query
+)
+
+// DateWindow is the pubdate range searched when a query does not give one.
+// The zero value searches only today's items.
+type DateWindow struct {
+	Mode WindowMode
+	Days int       // Used with WindowDays
+	From time.Time // Used with WindowRange; zero leaves the start open
+	To   time.Time // Used with WindowRange; zero leaves the end open
+}
+
+// SetDateWindow sets the pubdate range used for queries without one
+func (s *MongoSearch) SetDateWindow(w DateWindow) {
+	s.window = w
+}
+
+// SetClock replaces time.Now as the source of the current time for date
+// windows. Mostly useful for testing.
+func (s *MongoSearch) SetClock(now func() time.Time) {
+	s.clock = now
+}
+
+// SetLocation sets the time zone days are counted in. The date converters,
+// such as DateConverter, read dates without a zone of their own in loc, and
+// relative dates and the date window are resolved in it. Defaults to UTC.
+func (s *MongoSearch) SetLocation(loc *time.Location) {
+	s.location = loc
+}
+
+// now returns the current time according to the configured clock, in the
+// configured location
+func (s *MongoSearch) now() (now time.Time) {
+	if now = time.Now(); s.clock != nil {
+		now = s.clock()
+	}
+	if s.location != nil {
+		now = now.In(s.location)
+	}
+	return
+}
+
+// SetDateLayouts sets the layouts dates in queries are read with. The date
+// converters, such as DateConverter, use them unless given layouts of their
+// own. Defaults to defaultLayouts.
+func (s *MongoSearch) SetDateLayouts(layouts ...string) {
+	s.layouts = append([]string(nil), layouts...)
+}
+
+// dateLayouts returns the configured layouts or the defaults
+func (s *MongoSearch) dateLayouts() []string {
+	if len(s.layouts) == 0 {
+		return defaultLayouts()
+	}
+	return s.layouts
+}
+
+// dateBound returns the value standing in for t in a subquery. Converters are
+// handed t itself through Conversion.Time, so need not parse it back.
+func (s *MongoSearch) dateBound(t time.Time) (value string) {
+	value = t.Format(time.RFC3339Nano)
+	if s.dates == nil {
+		s.dates = make(map[string]time.Time)
+	}
+	s.dates[value] = t
+	return
+}
+
+// startOfDay returns midnight of the day t falls on in the configured location
+func (s *MongoSearch) startOfDay(t time.Time) time.Time {
+	if s.location != nil {
+		t = t.In(s.location)
+	}
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// windowSubqueries returns the pubdate subqueries standing in for a query
+// without any. Days are whole, so the bounds suit date and datetime fields
+// alike.
+func (s *MongoSearch) windowSubqueries() (dates []searchquery.SubQuery, err error) {
+	bound := func(sq searchquery.SubQuery, t time.Time) searchquery.SubQuery {
+		sq.Field, sq.Value = s.fields.pubdate, s.dateBound(t)
+		return sq
+	}
+	gte := searchquery.SubQuery{Operator: searchquery.OperatorRelGTE}
+	lt := searchquery.SubQuery{Operator: searchquery.OperatorRelLT}
+	today := s.startOfDay(s.now())
+
+	switch s.window.Mode {
+	case WindowToday:
+		dates = append(dates, bound(gte, today), bound(lt, today.AddDate(0, 0, 1)))
+	case WindowNone:
+	case WindowDays:
+		if s.window.Days < 1 {
+			return nil, fmt.Errorf("Invalid date window of %d days", s.window.Days)
+		}
+		dates = append(dates, bound(gte, today.AddDate(0, 0, 1-s.window.Days)))
+	case WindowRange:
+		if !s.window.From.IsZero() {
+			dates = append(dates, bound(gte, s.startOfDay(s.window.From)))
+		}
+		if !s.window.To.IsZero() {
+			dates = append(dates, bound(lt, s.startOfDay(s.window.To).AddDate(0, 0, 1)))
+		}
+	case WindowRequired:
+		return nil, fmt.Errorf("Query must include a %s clause", s.fields.pubdate)
+	default:
+		return nil, fmt.Errorf("Unknown date window mode: %d", s.window.Mode)
+	}
+	return
+}
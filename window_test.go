@@ -0,0 +1,91 @@
+package mongosearch
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDateWindow(t *testing.T) {
+	clock := func() time.Time {
+		return time.Date(2014, 6, 23, 15, 4, 5, 0, time.UTC)
+	}
+	tests := []struct {
+		Window DateWindow
+		Query  string
+		Err    bool
+	}{
+		{
+			DateWindow{},
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":20140623,"$lt":20140624}}]}`,
+			false,
+		},
+		{
+			DateWindow{Mode: WindowNone},
+			`{"$or":[{"keywords":"a"}]}`,
+			false,
+		},
+		{
+			DateWindow{Mode: WindowDays, Days: 7},
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":20140617}}]}`,
+			false,
+		},
+		{
+			DateWindow{Mode: WindowDays},
+			``,
+			true,
+		},
+		{
+			DateWindow{Mode: WindowRange, From: clock().AddDate(0, -1, 0), To: clock().AddDate(0, 0, -1)},
+			`{"$or":[{"keywords":"a","pubdate":{"$gte":20140523,"$lt":20140623}}]}`,
+			false,
+		},
+		{
+			DateWindow{Mode: WindowRequired},
+			``,
+			true,
+		},
+	}
+
+	for i, test := range tests {
+		ms, _ := New("", "Items", "Results")
+		ms.SetAll("all")
+		ms.SetKeyword("keywords", ConvertSpaces)
+		ms.SetPubdate("pubdate", ConvertDateInt, "published")
+		ms.SetPubid("pubid", ConvertBsonId)
+		ms.SetDateWindow(test.Window)
+		ms.SetClock(clock)
+
+		query, err := parseQuery(`keywords:a`)
+		if err != nil {
+			t.Fatalf("parseQuery: %s", err)
+		}
+		mgoQuery, err := ms.buildQuery(query)
+		if test.Err {
+			if err == nil {
+				t.Errorf("[%d] Expected an error, got %v", i, mgoQuery)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[%d] buildQuery: %s", i, err)
+		}
+		if b, _ := json.Marshal(mgoQuery); string(b) != test.Query {
+			t.Errorf("[%d] Expect: %s", i, test.Query)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+
+		// An explicit date always wins
+		query, _ = parseQuery(`published:2014-06-01 AND keywords:a`)
+		mgoQuery, err = ms.buildQuery(query)
+		if err != nil {
+			t.Errorf("[%d] buildQuery with date: %s", i, err)
+			continue
+		}
+		expect := `{"$or":[{"keywords":"a","pubdate":20140601}]}`
+		if b, _ := json.Marshal(mgoQuery); string(b) != expect {
+			t.Errorf("[%d] Expect: %s", i, expect)
+			t.Errorf("[%d] Got:    %s", i, b)
+		}
+	}
+}